@@ -39,15 +39,279 @@ func (g *apiGenerator) addMessage(m *schema.MessageData) bool {
 }
 
 func (g *apiGenerator) getFileName() string {
+	return strcase.ToSnake(g.getName()) + "_gen.go"
+}
+
+func (g *apiGenerator) getMessages() []*schema.MessageData {
+	return []*schema.MessageData{g.req, g.res}
+}
+
+func (g *apiGenerator) getName() string {
 	req := strings.TrimSuffix(g.req.Name, "Request")
 	res := strings.TrimSuffix(g.res.Name, "Response")
 	if req != res {
 		panic(fmt.Errorf("mismatched req/res names; %s != %s", req, res))
 	}
-	return strcase.ToSnake(req) + "_gen.go"
+	return req
 }
 
 func (g *apiGenerator) run(w *codegen.File) {
+	w.WriteString("import (\n\"context\"\n\"fmt\"\n\"sync\"\n")
+	if usesTables(g.req, g.res) {
+		w.WriteString("\"unsafe\"\n")
+	}
+	w.WriteString(")\n\n")
+
 	genMessage(w, g.req)
 	genMessage(w, g.res)
+	g.genHandler(w)
+	g.genErrorResponse(w)
+
+	switch *g.req.ApiKey {
+	case 0:
+		g.genProduce(w)
+	case 3:
+		g.genMetadata(w)
+	case 18:
+		g.genAPIVersions(w)
+	}
+}
+
+// genAPIVersions lets the server build ApiVersions responses from the
+// registry without naming the generated types.
+func (g *apiGenerator) genAPIVersions(w *codegen.File) {
+	keys := findField(g.res.Fields, "ApiKeys")
+	if keys == nil {
+		panic("ApiVersionsResponse has no ApiKeys field")
+	}
+
+	begMethod(w, g.res.Name, "setAPIVersions", "errorCode int16, apis []*API", "")
+	w.WriteString("m.Reset()\nm.ErrorCode = errorCode\nm.ApiKeys = make([]")
+	w.WriteString(keys.Type.Elem)
+	w.WriteString(", len(apis))\nfor i, a := range apis {\n")
+	w.WriteString("k := &m.ApiKeys[i]\nk.Reset()\n")
+	w.WriteString("k.ApiKey = a.Key\nk.MinVersion = a.MinVersion\nk.MaxVersion = a.MaxVersion\n")
+	w.WriteString("}\n")
+	endMethod(w)
+
+	begMethod(w, g.res.Name, "apiVersions", "", "(int16, []API)")
+	w.WriteString("apis := make([]API, len(m.ApiKeys))\nfor i := range m.ApiKeys {\n")
+	w.WriteString("k := &m.ApiKeys[i]\n")
+	w.WriteString("apis[i] = API{Key: k.ApiKey, MinVersion: k.MinVersion, MaxVersion: k.MaxVersion}\n")
+	w.WriteString("}\nreturn m.ErrorCode, apis\n")
+	endMethod(w)
+}
+
+// genErrorResponse lets the server answer a failed request with an error
+// code, for responses that have one at the top level.
+func (g *apiGenerator) genErrorResponse(w *codegen.File) {
+	f := findField(g.res.Fields, "ErrorCode")
+	if f == nil || f.Type.Array || f.Type.Elem != "int16" || isTagged(f) {
+		return
+	}
+	begMethod(w, g.res.Name, "setErrorCode", "code, v int16", "bool")
+	if !versionsCover(f.Versions, g.res.ValidVersions) {
+		w.WriteString("if !(")
+		genVersionCond(w, f.Versions, g.res.ValidVersions)
+		w.WriteString(") {\nreturn false\n}\n")
+	}
+	w.WriteString("m.Reset()\nm.ErrorCode = code\nreturn true\n")
+	endMethod(w)
+}
+
+// genProduce lets the runtime tell that produce requests without acks get no
+// response.
+func (g *apiGenerator) genProduce(w *codegen.File) {
+	if findField(g.req.Fields, "Acks") == nil {
+		panic("ProduceRequest has no Acks field")
+	}
+	begMethod(w, g.req.Name, "noResponse", "", "bool")
+	w.WriteString("return m.Acks == 0\n")
+	endMethod(w)
+}
+
+// genMetadata lets the client fetch cluster metadata without naming the
+// generated types. Fields missing from the schema are left at their zero
+// values.
+func (g *apiGenerator) genMetadata(w *codegen.File) {
+	topics := arrayField(findField(g.req.Fields, "Topics"))
+
+	begMethod(w, g.req.Name, "setTopics", "topics []TopicName", "")
+	w.WriteString("m.Reset()\nif topics != nil {\na := make([]")
+	w.WriteString(topics.Type.Elem)
+	w.WriteString(", len(topics))\nfor i, name := range topics {\n")
+	w.WriteString("t := &a[i]\nt.Reset()\nt.Name = ")
+	if entityType(findField(topics.Fields, "Name")) != "" {
+		w.WriteString("name\n}\n")
+	} else {
+		w.WriteString("string(name)\n}\n")
+	}
+	if topics.Lazy {
+		w.WriteString("m.Topics = New")
+		w.WriteString(topics.Type.Elem)
+		w.WriteString("View(a)\n}\n")
+	} else {
+		w.WriteString("m.Topics = a\n}\n")
+	}
+	if findField(g.req.Fields, "AllowAutoTopicCreation") != nil {
+		w.WriteString("m.AllowAutoTopicCreation = false\n")
+	}
+	endMethod(w)
+
+	brokers := arrayField(findField(g.res.Fields, "Brokers"))
+	topics = arrayField(findField(g.res.Fields, "Topics"))
+	partitions := arrayField(findField(topics.Fields, "Partitions"))
+
+	begMethod(w, g.res.Name, "cluster", "", "*Cluster")
+	w.WriteString("brokers := ")
+	genArrayValue(w, brokers, "m")
+	w.WriteString("\ntopics := ")
+	genArrayValue(w, topics, "m")
+	w.WriteString("\nc := &Cluster{\nControllerID: -1,\n")
+	w.WriteString("Brokers: make(map[BrokerID]*Broker, len(brokers)),\n")
+	w.WriteString("Topics: make(map[TopicName]*Topic, len(topics)),\n}\n")
+	if f := findField(g.res.Fields, "ControllerId"); f != nil {
+		w.WriteString("c.ControllerID = ")
+		genEntityValue(w, f, "m", "BrokerID")
+		w.WriteByte('\n')
+	}
+
+	w.WriteString("for i := range brokers {\nb := &brokers[i]\nid := ")
+	genEntityValue(w, findField(brokers.Fields, "NodeId"), "b", "BrokerID")
+	w.WriteString("\nc.Brokers[id] = &Broker{\nID: id,\n")
+	genFieldsCopy(w, brokers.Fields, "b", [][3]string{
+		{"Host", "Host"},
+		{"Port", "Port"},
+		{"Rack", "Rack"},
+	})
+	w.WriteString("}\n}\n")
+
+	w.WriteString("for i := range topics {\nt := &topics[i]\nname := ")
+	genEntityValue(w, findField(topics.Fields, "Name"), "t", "TopicName")
+	w.WriteString("\npartitions := ")
+	genArrayValue(w, partitions, "t")
+	w.WriteString("\nct := &Topic{\nName: name,\n")
+	genFieldsCopy(w, topics.Fields, "t", [][3]string{
+		{"ErrorCode", "ErrorCode"},
+		{"Internal", "IsInternal"},
+	})
+	w.WriteString("Partitions: make(map[int32]*Partition, len(partitions)),\n}\n")
+
+	w.WriteString("for j := range partitions {\np := &partitions[j]\n")
+	w.WriteString("ct.Partitions[p.PartitionIndex] = &Partition{\nTopic: name,\n")
+	if findField(partitions.Fields, "LeaderEpoch") == nil {
+		w.WriteString("LeaderEpoch: -1,\n")
+	}
+	genFieldsCopy(w, partitions.Fields, "p", [][3]string{
+		{"Index", "PartitionIndex"},
+		{"ErrorCode", "ErrorCode"},
+		{"Leader", "LeaderId", "BrokerID"},
+		{"LeaderEpoch", "LeaderEpoch"},
+		{"Replicas", "ReplicaNodes", "BrokerID"},
+		{"ISR", "IsrNodes", "BrokerID"},
+		{"Offline", "OfflineReplicas", "BrokerID"},
+	})
+	w.WriteString("}\n}\nc.Topics[name] = ct\n}\nreturn c\n")
+	endMethod(w)
+}
+
+func (g *apiGenerator) genHandler(w *codegen.File) {
+	name := g.getName()
+	sig := "(ctx context.Context, r *Request, req *" + g.req.Name + ") (*" + g.res.Name + ", error)"
+
+	w.Writef("type %sHandler interface {\nServe%s%s\n}\n\n", name, name, sig)
+	w.Writef("type %sHandlerFunc func%s\n\n", name, sig)
+	w.Writef("func (f %sHandlerFunc) Serve%s%s {\nreturn f(ctx, r, req)\n}\n\n", name, name, sig)
+
+	w.Writef("func (s *Server) Handle%s(h %sHandler) {\n", name, name)
+	w.WriteString("s.handle(")
+	w.WriteInt(int64(*g.req.ApiKey), 10)
+	w.WriteString(", func(ctx context.Context, r *Request) (Message, error) {\n")
+	w.Writef("res, err := h.Serve%s(ctx, r, r.Body.(*%s))\n", name, g.req.Name)
+	w.WriteString("if res == nil {\nreturn nil, err\n}\nreturn res, err\n})\n}\n\n")
+}
+
+// arrayField returns the array that f views, if it is lazy.
+func arrayField(f *schema.Field) *schema.Field {
+	if f.ViewOf != nil {
+		return f.ViewOf
+	}
+	return f
+}
+
+// genArrayValue writes the elements of the array field f of src as a slice.
+func genArrayValue(w *codegen.File, f *schema.Field, src string) {
+	w.WriteString(src)
+	w.WriteByte('.')
+	w.WriteString(f.Name)
+	if f.Lazy {
+		w.WriteString(".Slice()")
+	}
+}
+
+func findField(fields []*schema.Field, name string) *schema.Field {
+	for _, f := range fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// genEntityValue writes the field f of src converted to the entity type et,
+// which older schemas do not declare.
+func genEntityValue(w *codegen.File, f *schema.Field, src, et string) {
+	switch {
+	case f.Type.Array:
+		// Arrays are copied, since the message is released afterwards.
+		if et != "BrokerID" {
+			panic("no conversion to []" + et)
+		}
+		if entityType(f) == et {
+			w.WriteString("append([]BrokerID(nil), ")
+			w.WriteString(src)
+			w.WriteByte('.')
+			w.WriteString(f.Name)
+			w.WriteString("...)")
+			return
+		}
+		w.WriteString("brokerIDs(")
+		w.WriteString(src)
+		w.WriteByte('.')
+		w.WriteString(f.Name)
+		w.WriteByte(')')
+	case entityType(f) == et:
+		w.WriteString(src)
+		w.WriteByte('.')
+		w.WriteString(f.Name)
+	default:
+		w.WriteString(et)
+		w.WriteByte('(')
+		w.WriteString(src)
+		w.WriteByte('.')
+		w.WriteString(f.Name)
+		w.WriteByte(')')
+	}
+}
+
+// genFieldsCopy writes the composite literal elements copying each named
+// field of src that exists in fields, converting it to the entity type given
+// as the third name, if any.
+func genFieldsCopy(w *codegen.File, fields []*schema.Field, src string, names [][3]string) {
+	for _, n := range names {
+		f := findField(fields, n[1])
+		if f == nil {
+			continue
+		}
+		w.WriteString(n[0])
+		w.WriteString(": ")
+		if n[2] != "" {
+			genEntityValue(w, f, src, n[2])
+		} else {
+			w.WriteString(src)
+			w.WriteByte('.')
+			w.WriteString(n[1])
+		}
+		w.WriteString(",\n")
+	}
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+// benchArrayLen is the number of elements given to each array when filling
+// messages for benchmarks.
+const benchArrayLen = "4"
+
+// genBenchmarks writes benchmarks encoding and decoding each message at its
+// lowest and highest versions.
+func genBenchmarks(w *codegen.File, ms ...*schema.MessageData) {
+	w.WriteString("import \"testing\"\n\n")
+
+	for _, m := range ms {
+		v := m.ValidVersions
+
+		w.WriteString("func Benchmark")
+		w.WriteString(m.Name)
+		w.WriteString("(b *testing.B) {\nm := new(")
+		w.WriteString(m.Name)
+		w.WriteString(")\nm.Reset()\nm.benchFill(0)\nbenchmarkMessage(b, m, func() Message {\nreturn new(")
+		w.WriteString(m.Name)
+		w.WriteString(")\n}, ")
+		w.WriteInt(int64(v.Min), 10)
+		w.WriteString(", ")
+		w.WriteInt(int64(v.Max), 10)
+		w.WriteString(")\n}\n\n")
+
+		genStructBenchFill(w, m.Name, m.Fields)
+		for _, s := range m.CommonStructs {
+			genStructBenchFill(w, s.Name, s.Fields)
+		}
+		for _, f := range lazyFields(m) {
+			begMethod(w, f.Type.Elem+"View", "benchFill", "i int", "")
+			w.WriteString("a := make([]")
+			w.WriteString(f.Type.Elem)
+			w.WriteString(", " + benchArrayLen + ")\nfor j := range a {\na[j].Reset()\na[j].benchFill(j)\n}\n*m = New")
+			w.WriteString(f.Type.Elem)
+			w.WriteString("View(a)\n")
+			endMethod(w)
+		}
+	}
+}
+
+// genBenchHelpers writes the helpers shared by the generated benchmarks.
+func genBenchHelpers(w *codegen.File) {
+	w.WriteString("import (\n\"bytes\"\n\"strconv\"\n\"testing\"\n)\n\n")
+
+	// Decoding goes into messages from fresh, leaving m whole for the later
+	// versions, and a round trip and the size of the encoding are checked
+	// before anything is timed.
+	w.WriteString("func benchmarkMessage(b *testing.B, m Message, fresh func() Message, min, max int16) {\n")
+	w.WriteString("for _, v := range []int16{min, max} {\n")
+	w.WriteString("v, buf := v, appendMessage(nil, m, v)\n")
+	w.WriteString("x := fresh()\nbenchDecode(b, x, buf, v)\n")
+	w.WriteString("if !bytes.Equal(appendMessage(nil, x, v), buf) {\nb.Fatalf(\"v%d: round trip changed the encoding\", v)\n}\n")
+	w.WriteString("if n := m.size(v); n != len(buf) {\nb.Fatalf(\"v%d: size %d, encoded %d bytes\", v, n, len(buf))\n}\n")
+	w.WriteString("name := \"v\" + strconv.Itoa(int(v))\n")
+	w.WriteString("b.Run(name+\"/encode\", func(b *testing.B) {\n")
+	w.WriteString("b.SetBytes(int64(len(buf)))\nb.ReportAllocs()\n")
+	w.WriteString("out := make([]byte, 0, len(buf))\n")
+	w.WriteString("for i := 0; i < b.N; i++ {\nout = appendMessage(out[:0], m, v)\n}\n})\n")
+	w.WriteString("b.Run(name+\"/decode\", func(b *testing.B) {\n")
+	w.WriteString("b.SetBytes(int64(len(buf)))\nb.ReportAllocs()\n")
+	w.WriteString("x := fresh()\nfor i := 0; i < b.N; i++ {\nbenchDecode(b, x, buf, v)\n}\n})\n")
+	w.WriteString("}\n}\n\n")
+
+	w.WriteString("func benchDecode(b *testing.B, m Message, buf []byte, v int16) {\n")
+	w.WriteString("d := decoder{b: buf, end: len(buf)}\n")
+	w.WriteString("if err := decodeMessage(m, &d, v, true); err != nil {\nb.Fatal(err)\n}\n}\n\n")
+
+	w.WriteString("func benchName(s string, i int) string {\nreturn s + \"-\" + strconv.Itoa(i)\n}\n\n")
+	w.WriteString("func benchString(s string, i int) *string {\ns = benchName(s, i)\nreturn &s\n}\n")
+}
+
+// genStructBenchFill writes a method setting every field of a struct to a
+// typical value. Values vary with i, so that the elements of keyed arrays are
+// distinct.
+func genStructBenchFill(w *codegen.File, name string, fields []*schema.Field) {
+	begMethod(w, name, "benchFill", "i int", "")
+	for _, f := range fields {
+		t := &f.Type
+
+		switch {
+		case !t.Array && isStructType(t.Elem):
+			w.WriteString("m.")
+			w.WriteString(f.Name)
+			w.WriteString(".benchFill(i)\n")
+			continue
+		case !t.Array:
+			w.WriteString("m.")
+			w.WriteString(f.Name)
+			w.WriteString(" = ")
+			w.WriteString(benchValue(f, "i"))
+			w.WriteByte('\n')
+			continue
+		}
+
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(" = make(")
+		w.WriteString(fieldType(f))
+		w.WriteString(", " + benchArrayLen + ")\n")
+		w.WriteString("for j := range m.")
+		w.WriteString(f.Name)
+		w.WriteString(" {\n")
+		if isStructType(t.Elem) {
+			w.WriteString("m.")
+			w.WriteString(f.Name)
+			w.WriteString("[j].Reset()\nm.")
+			w.WriteString(f.Name)
+			w.WriteString("[j].benchFill(j)\n")
+		} else {
+			w.WriteString("m.")
+			w.WriteString(f.Name)
+			w.WriteString("[j] = ")
+			w.WriteString(benchValue(f, "j"))
+			w.WriteByte('\n')
+		}
+		w.WriteString("}\n")
+	}
+	endMethod(w)
+
+	for _, f := range fields {
+		if f.Fields != nil {
+			genStructBenchFill(w, f.Type.Elem, f.Fields)
+		}
+	}
+}
+
+// benchValue returns an expression for a typical value of f, or of an element
+// of it, varying with the int i.
+func benchValue(f *schema.Field, i string) string {
+	switch t := f.Type.Elem; t {
+	case "bool", "boolean":
+		return "true"
+	case "int8":
+		return benchInt(f, "1 + "+i)
+	case "int16":
+		return benchInt(f, "10 + "+i)
+	case "int32":
+		return benchInt(f, "100000 + "+i)
+	case "int64":
+		return benchInt(f, "1<<40 + "+i)
+	case "string":
+		name := "\"" + strings.ToLower(f.Name) + "\", " + i
+		if !f.Type.Array && isNullable(f) {
+			return entityConv(f) + "(benchString(" + name + "))"
+		}
+		if et := entityType(f); et != "" {
+			return et + "(benchName(" + name + "))"
+		}
+		return "benchName(" + name + ")"
+	case "bytes":
+		return "make([]byte, 256)"
+	case "records":
+		return "make([]byte, 16<<10)"
+	default:
+		panic("unexpected type: " + t)
+	}
+}
+
+func benchInt(f *schema.Field, val string) string {
+	t := entityType(f)
+	if t == "" {
+		t = f.Type.Elem
+	}
+	return t + "(" + val + ")"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+func genFieldClone(w *codegen.File, f *schema.Field) {
+	t := &f.Type
+
+	switch {
+	case t.Array || t.Elem == "bytes" || t.Elem == "records":
+		w.WriteString("if m.")
+		w.WriteString(f.Name)
+		w.WriteString(" != nil {\nc.")
+		w.WriteString(f.Name)
+		w.WriteString(" = make(")
+		genFieldType(w, f)
+		w.WriteString(", len(m.")
+		w.WriteString(f.Name)
+		w.WriteString("))\n")
+		if t.Array && isStructType(t.Elem) {
+			w.WriteString("for i := range m.")
+			w.WriteString(f.Name)
+			w.WriteString(" {\nm.")
+			w.WriteString(f.Name)
+			w.WriteString("[i].cloneInto(&c.")
+			w.WriteString(f.Name)
+			w.WriteString("[i])\n}\n")
+		} else if t.Elem == "string" && *zeroCopy {
+			w.WriteString("for i := range m.")
+			w.WriteString(f.Name)
+			w.WriteString(" {\nc.")
+			w.WriteString(f.Name)
+			w.WriteString("[i] = ")
+			genCloneString(w, f, "m."+f.Name+"[i]")
+			w.WriteString("\n}\n")
+		} else {
+			w.WriteString("copy(c.")
+			w.WriteString(f.Name)
+			w.WriteString(", m.")
+			w.WriteString(f.Name)
+			w.WriteString(")\n")
+		}
+		w.WriteString("}\n")
+	case isStructType(t.Elem):
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(".cloneInto(&c.")
+		w.WriteString(f.Name)
+		w.WriteString(")\n")
+	case t.Elem == "string" && isNullable(f):
+		w.WriteString("if m.")
+		w.WriteString(f.Name)
+		w.WriteString(" != nil {\ns := ")
+		if *zeroCopy {
+			genCloneString(w, f, "*m."+f.Name)
+		} else {
+			w.WriteString("*m.")
+			w.WriteString(f.Name)
+		}
+		w.WriteString("\nc.")
+		w.WriteString(f.Name)
+		w.WriteString(" = &s\n}\n")
+	case t.Elem == "string" && *zeroCopy:
+		w.WriteString("c.")
+		w.WriteString(f.Name)
+		w.WriteString(" = ")
+		genCloneString(w, f, "m."+f.Name)
+		w.WriteByte('\n')
+	}
+}
+
+// genCloneString copies a string that the -zerocopy flag lets alias the frame.
+func genCloneString(w *codegen.File, f *schema.Field, val string) {
+	et := entityType(f)
+	if et == "" {
+		w.WriteString("cloneString(")
+		w.WriteString(val)
+		w.WriteByte(')')
+		return
+	}
+	w.WriteString(et)
+	w.WriteString("(cloneString(string(")
+	w.WriteString(val)
+	w.WriteString(")))")
+}
+
+func genStructClone(w *codegen.File, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "Clone", "", "*"+recv)
+	w.WriteString("if m == nil {\nreturn nil\n}\nc := new(")
+	w.WriteString(recv)
+	w.WriteString(")\nm.cloneInto(c)\nreturn c\n")
+	endMethod(w)
+
+	begMethod(w, recv, "cloneInto", "c *"+recv, "")
+	w.WriteString("*c = *m\n")
+
+	for _, f := range fields {
+		genFieldClone(w, f)
+	}
+
+	endMethod(w)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/betawaffle/kafka-gen-go/kafkaproto"
+)
+
+type dumper struct {
+	port  uint16
+	out   *json.Encoder
+	conns map[string]*conn
+	order []*conn
+}
+
+type conn struct {
+	*dumper
+	id string
+
+	req, res halfStream
+	broken   bool
+	now      time.Time
+	pending  map[int32]*exchange
+}
+
+type message struct {
+	Header interface{} `json:"header,omitempty"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+type exchange struct {
+	Time          *time.Time `json:"time,omitempty"`
+	Conn          string     `json:"conn"`
+	API           string     `json:"api,omitempty"`
+	APIKey        int16      `json:"api_key"`
+	APIVersion    int16      `json:"api_version"`
+	CorrelationID int32      `json:"correlation_id"`
+	LatencyMs     float64    `json:"latency_ms,omitempty"`
+
+	Request  *message `json:"request,omitempty"`
+	Response *message `json:"response,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+func (d *dumper) addSegment(ts time.Time, s *segment) {
+	var client, broker string
+	var res bool
+	switch {
+	case s.dport == d.port:
+		client, broker = s.srcAddr(), s.dstAddr()
+	case s.sport == d.port:
+		client, broker, res = s.dstAddr(), s.srcAddr(), true
+	default:
+		return
+	}
+
+	id := client + " -> " + broker
+	if _, ok := d.conns[id]; !ok && !s.syn && len(s.payload) == 0 {
+		return // the tail of a connection that was already closed
+	}
+	c := d.getConn(id)
+	if s.syn && !res {
+		c.reset()
+	}
+	c.now = ts
+
+	h := &c.req
+	if res {
+		h = &c.res
+	}
+	h.add(s.seq, s.syn, s.payload)
+	c.drain(res)
+
+	if s.fin {
+		h.fin = true
+	}
+	if s.rst || c.req.fin && c.res.fin {
+		d.close(c)
+	}
+}
+
+// close forgets a connection once it is torn down, printing the requests
+// that never saw a response.
+func (d *dumper) close(c *conn) {
+	c.flush()
+	delete(d.conns, c.id)
+	for i, o := range d.order {
+		if o == c {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// flush prints the requests that never saw a response.
+func (d *dumper) flush() {
+	for _, c := range d.order {
+		c.flush()
+	}
+}
+
+func (d *dumper) getConn(id string) *conn {
+	c, ok := d.conns[id]
+	if !ok {
+		c = &conn{dumper: d, id: id}
+		c.reset()
+		d.conns[id] = c
+		d.order = append(d.order, c)
+	}
+	return c
+}
+
+func (d *dumper) print(x *exchange) {
+	if err := d.out.Encode(x); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (c *conn) drain(res bool) {
+	h := &c.req
+	if res {
+		h = &c.res
+	}
+	for !c.broken {
+		b, ok, err := h.frame()
+		if err != nil {
+			c.broken = true
+			log.Printf("%s: %v; ignoring the rest of the connection", c.id, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		if res {
+			c.addResponse(b)
+		} else {
+			c.addRequest(b)
+		}
+	}
+}
+
+func (c *conn) addRequest(b []byte) {
+	x := &exchange{Conn: c.id, Request: new(message)}
+	if !c.now.IsZero() {
+		t := c.now
+		x.Time = &t
+	}
+
+	r, err := kafkaproto.DecodeRequest(b)
+	if err != nil {
+		x.Errors = append(x.Errors, "request: "+err.Error())
+	}
+	if r == nil {
+		c.print(x)
+		return
+	}
+	x.APIKey, x.APIVersion, x.CorrelationID = r.APIKey, r.APIVersion, r.CorrelationID
+	x.Request.Header, x.Request.Body = r.Header, kafkaproto.JSONValue(r.Body, r.APIVersion)
+	if a := kafkaproto.LookupAPI(r.APIKey); a != nil {
+		x.API = a.Name
+	}
+	if old, ok := c.pending[r.CorrelationID]; ok {
+		c.print(old)
+	}
+	if err == nil && !kafkaproto.ExpectsResponse(r.Body) {
+		c.print(x)
+		return
+	}
+	c.pending[r.CorrelationID] = x
+}
+
+func (c *conn) addResponse(b []byte) {
+	corr, err := kafkaproto.ResponseCorrelationID(b)
+	if err != nil {
+		c.print(&exchange{Conn: c.id, Errors: []string{"response: " + err.Error()}})
+		return
+	}
+	x, ok := c.pending[corr]
+	if !ok {
+		c.print(&exchange{Conn: c.id, CorrelationID: corr, Errors: []string{"response: no request with this correlation id"}})
+		return
+	}
+	delete(c.pending, corr)
+
+	if x.Time != nil && !c.now.IsZero() {
+		x.LatencyMs = float64(c.now.Sub(*x.Time)) / float64(time.Millisecond)
+	}
+	r, err := kafkaproto.DecodeResponse(b, x.APIKey, x.APIVersion)
+	if err != nil {
+		x.Errors = append(x.Errors, "response: "+err.Error())
+	}
+	if r != nil {
+		x.Response = &message{Header: r.Header, Body: kafkaproto.JSONValue(r.Body, x.APIVersion)}
+	}
+	c.print(x)
+}
+
+func (c *conn) flush() {
+	a := make([]*exchange, 0, len(c.pending))
+	for _, x := range c.pending {
+		a = append(a, x)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].CorrelationID < a[j].CorrelationID })
+	for _, x := range a {
+		c.print(x)
+	}
+	c.pending = make(map[int32]*exchange)
+}
+
+func (c *conn) reset() {
+	c.flush()
+	c.req.reset()
+	c.res.reset()
+	c.broken = false
+}
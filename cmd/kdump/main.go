@@ -0,0 +1,92 @@
+// Command kdump decodes Kafka traffic from a pcap capture or from raw byte
+// streams, pairing requests with their responses and printing each exchange
+// as a line of JSON.
+//
+// Usage:
+//
+//	kdump [-port 9092] capture.pcap
+//	kdump -raw requests.bin [responses.bin]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+var errFrameSize = errors.New("invalid frame size")
+
+func main() {
+	port := flag.Uint("port", 9092, "broker port, used to tell requests from responses")
+	raw := flag.Bool("raw", false, "read raw client and broker byte streams instead of a pcap file")
+	flag.Parse()
+
+	log.SetFlags(0)
+	d := &dumper{
+		port:  uint16(*port),
+		out:   json.NewEncoder(os.Stdout),
+		conns: make(map[string]*conn),
+	}
+	d.out.SetEscapeHTML(false)
+
+	var err error
+	switch {
+	case *raw && flag.NArg() >= 1 && flag.NArg() <= 2:
+		err = d.readRaw(flag.Args())
+	case !*raw && flag.NArg() == 1:
+		err = d.readPCAP(flag.Arg(0))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	d.flush()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (d *dumper) readPCAP(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p, err := newPCAPReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		ts, b, err := p.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s, ok := decodePacket(p.linkType, b); ok {
+			d.addSegment(ts, s)
+		}
+	}
+}
+
+func (d *dumper) readRaw(names []string) error {
+	c := d.getConn(names[0])
+	for i, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		h := &c.req
+		if i == 1 {
+			h = &c.res
+		}
+		h.buf = b
+		c.drain(h == &c.res)
+	}
+	return nil
+}
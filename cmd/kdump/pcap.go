@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+var (
+	errPCAPMagic  = errors.New("not a pcap file")
+	errPCAPRecord = errors.New("pcap record exceeds the snapshot length")
+)
+
+// maxSnapLen bounds the packets read from a capture, whatever its header
+// claims. It is the largest snapshot length tcpdump uses.
+const maxSnapLen = 262144
+
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+type pcapReader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	nano     bool
+	snapLen  int
+	linkType uint32
+	buf      []byte
+}
+
+func newPCAPReader(r io.Reader) (*pcapReader, error) {
+	p := &pcapReader{r: bufio.NewReader(r)}
+
+	var hdr [24]byte
+	if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+		return nil, err
+	}
+	switch binary.LittleEndian.Uint32(hdr[:4]) {
+	case 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		p.order, p.nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		p.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		p.order, p.nano = binary.BigEndian, true
+	default:
+		return nil, errPCAPMagic
+	}
+	p.snapLen = int(p.order.Uint32(hdr[16:]))
+	if p.snapLen <= 0 || p.snapLen > maxSnapLen {
+		p.snapLen = maxSnapLen
+	}
+	p.linkType = p.order.Uint32(hdr[20:]) & 0x0fffffff
+	return p, nil
+}
+
+// next returns the next captured packet. The data is only valid until the
+// following call.
+func (p *pcapReader) next() (time.Time, []byte, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+		return time.Time{}, nil, err
+	}
+	sec := int64(p.order.Uint32(hdr[0:]))
+	frac := int64(p.order.Uint32(hdr[4:]))
+	if !p.nano {
+		frac *= int64(time.Microsecond)
+	}
+	n := int(p.order.Uint32(hdr[8:]))
+	if n > p.snapLen {
+		return time.Time{}, nil, errPCAPRecord
+	}
+	if cap(p.buf) < n {
+		p.buf = make([]byte, n)
+	}
+	p.buf = p.buf[:n]
+	if _, err := io.ReadFull(p.r, p.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return time.Time{}, nil, err
+	}
+	return time.Unix(sec, frac), p.buf, nil
+}
+
+type segment struct {
+	src, dst net.IP
+	sport    uint16
+	dport    uint16
+	seq      uint32
+	syn      bool
+	fin      bool
+	rst      bool
+	payload  []byte
+}
+
+func (s *segment) srcAddr() string {
+	return net.JoinHostPort(s.src.String(), strconv.Itoa(int(s.sport)))
+}
+
+func (s *segment) dstAddr() string {
+	return net.JoinHostPort(s.dst.String(), strconv.Itoa(int(s.dport)))
+}
+
+// decodePacket extracts the TCP segment from a captured link-layer frame. It
+// returns false for anything that is not an unfragmented TCP packet.
+func decodePacket(linkType uint32, b []byte) (*segment, bool) {
+	var proto uint16
+	switch linkType {
+	case linkNull:
+		if len(b) < 4 {
+			return nil, false
+		}
+		// The address family is in the capturing host's byte order.
+		family := binary.LittleEndian.Uint32(b)
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(b)
+		}
+		switch family {
+		case 2:
+			proto = 0x0800
+		case 24, 28, 30:
+			proto = 0x86dd
+		}
+		b = b[4:]
+	case linkEthernet:
+		if len(b) < 14 {
+			return nil, false
+		}
+		proto, b = binary.BigEndian.Uint16(b[12:]), b[14:]
+		for (proto == 0x8100 || proto == 0x88a8) && len(b) >= 4 {
+			proto, b = binary.BigEndian.Uint16(b[2:]), b[4:]
+		}
+	case linkLinuxSLL:
+		if len(b) < 16 {
+			return nil, false
+		}
+		proto, b = binary.BigEndian.Uint16(b[14:]), b[16:]
+	case linkSLL2:
+		if len(b) < 20 {
+			return nil, false
+		}
+		proto, b = binary.BigEndian.Uint16(b), b[20:]
+	case linkRaw, linkIPv4, linkIPv6:
+		if len(b) == 0 {
+			return nil, false
+		}
+		switch b[0] >> 4 {
+		case 4:
+			proto = 0x0800
+		case 6:
+			proto = 0x86dd
+		}
+	default:
+		return nil, false
+	}
+
+	s := new(segment)
+	switch proto {
+	case 0x0800:
+		if len(b) < 20 {
+			return nil, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:]))
+		if b[9] != 6 || ihl < 20 || total < ihl || total > len(b) {
+			return nil, false
+		}
+		if binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+			return nil, false // fragmented
+		}
+		s.src, s.dst = net.IP(b[12:16]), net.IP(b[16:20])
+		b = b[ihl:total]
+	case 0x86dd:
+		if len(b) < 40 {
+			return nil, false
+		}
+		next := b[6]
+		total := 40 + int(binary.BigEndian.Uint16(b[4:]))
+		if total > len(b) {
+			return nil, false
+		}
+		s.src, s.dst = net.IP(b[8:24]), net.IP(b[24:40])
+		b = b[40:total]
+		for next == 0 || next == 43 || next == 60 {
+			if len(b) < 8 {
+				return nil, false
+			}
+			n := (int(b[1]) + 1) * 8
+			if n > len(b) {
+				return nil, false
+			}
+			next, b = b[0], b[n:]
+		}
+		if next != 6 {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	if len(b) < 20 {
+		return nil, false
+	}
+	off := int(b[12]>>4) * 4
+	if off < 20 || off > len(b) {
+		return nil, false
+	}
+	s.sport = binary.BigEndian.Uint16(b[0:])
+	s.dport = binary.BigEndian.Uint16(b[2:])
+	s.seq = binary.BigEndian.Uint32(b[4:])
+	s.fin = b[13]&0x01 != 0
+	s.syn = b[13]&0x02 != 0
+	s.rst = b[13]&0x04 != 0
+	s.payload = b[off:]
+	return s, true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+)
+
+// rawPacket returns an IPv4 TCP packet between 10.0.0.1 and 10.0.0.2.
+func rawPacket(sport, dport uint16, seq uint32, flags byte, payload []byte) []byte {
+	b := make([]byte, 40, 40+len(payload))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(40+len(payload)))
+	b[9] = 6
+	copy(b[12:], []byte{10, 0, 0, 1})
+	copy(b[16:], []byte{10, 0, 0, 2})
+	if sport == 9092 {
+		copy(b[12:], []byte{10, 0, 0, 2})
+		copy(b[16:], []byte{10, 0, 0, 1})
+	}
+	binary.BigEndian.PutUint16(b[20:], sport)
+	binary.BigEndian.PutUint16(b[22:], dport)
+	binary.BigEndian.PutUint32(b[24:], seq)
+	b[32] = 5 << 4
+	b[33] = flags
+	return append(b, payload...)
+}
+
+func pcapFile(snapLen uint32, packets ...[]byte) []byte {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b, 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(b[4:], 2)
+	binary.LittleEndian.PutUint16(b[6:], 4)
+	binary.LittleEndian.PutUint32(b[16:], snapLen)
+	binary.LittleEndian.PutUint32(b[20:], linkRaw)
+	for _, p := range packets {
+		var h [16]byte
+		binary.LittleEndian.PutUint32(h[8:], uint32(len(p)))
+		binary.LittleEndian.PutUint32(h[12:], uint32(len(p)))
+		b = append(append(b, h[:]...), p...)
+	}
+	return b
+}
+
+func TestDump(t *testing.T) {
+	// A version 0 metadata request with correlation id 7, and its response.
+	req := []byte{0, 0, 0, 18, 0, 3, 0, 0, 0, 0, 0, 7, 0, 4, 't', 'e', 's', 't', 0, 0, 0, 0}
+	res := []byte{0, 0, 0, 12, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	// The request arrives out of order and partly retransmitted.
+	packets := [][]byte{
+		rawPacket(5000, 9092, 100, tcpSYN, nil),
+		rawPacket(9092, 5000, 200, tcpSYN|tcpACK, nil),
+		rawPacket(5000, 9092, 111, tcpACK, req[10:]),
+		rawPacket(5000, 9092, 101, tcpACK, req[:10]),
+		rawPacket(5000, 9092, 101, tcpACK, req[:12]),
+		rawPacket(9092, 5000, 201, tcpACK, res),
+	}
+	p, err := newPCAPReader(bytes.NewReader(pcapFile(65535, packets...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d := &dumper{port: 9092, out: json.NewEncoder(&out), conns: make(map[string]*conn)}
+	for {
+		ts, b, err := p.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, ok := decodePacket(p.linkType, b)
+		if !ok {
+			t.Fatal("packet not decoded")
+		}
+		d.addSegment(ts, s)
+	}
+	d.flush()
+
+	var x exchange
+	if err := json.Unmarshal(out.Bytes(), &x); err != nil {
+		t.Fatalf("%v in %s", err, out.Bytes())
+	}
+	if x.Conn != "10.0.0.1:5000 -> 10.0.0.2:9092" || x.APIKey != 3 || x.CorrelationID != 7 || x.Request == nil || x.Response == nil {
+		t.Errorf("printed %s", out.Bytes())
+	}
+	if n := bytes.Count(out.Bytes(), []byte("\n")); n != 1 {
+		t.Errorf("printed %d exchanges", n)
+	}
+}
+
+func TestPCAPSnapLen(t *testing.T) {
+	p, err := newPCAPReader(bytes.NewReader(pcapFile(64, rawPacket(1, 9092, 0, tcpSYN, make([]byte, 100)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.next(); err != errPCAPRecord {
+		t.Fatal(err)
+	}
+}
+
+func TestConnTeardown(t *testing.T) {
+	for _, end := range [][][]byte{
+		{
+			rawPacket(5000, 9092, 101, tcpFIN|tcpACK, nil),
+			rawPacket(9092, 5000, 201, tcpFIN|tcpACK, nil),
+		},
+		{
+			rawPacket(9092, 5000, 201, tcpRST, nil),
+		},
+	} {
+		packets := [][]byte{
+			rawPacket(5000, 9092, 100, tcpSYN, nil),
+			rawPacket(9092, 5000, 200, tcpSYN|tcpACK, nil),
+			rawPacket(5000, 9092, 101, tcpACK, nil),
+		}
+		packets = append(packets, end...)
+		packets = append(packets, rawPacket(5000, 9092, 102, tcpACK, nil))
+
+		d := &dumper{port: 9092, out: json.NewEncoder(ioutil.Discard), conns: make(map[string]*conn)}
+		for i, b := range packets {
+			s, ok := decodePacket(linkRaw, b)
+			if !ok {
+				t.Fatal("packet", i)
+			}
+			d.addSegment(time.Time{}, s)
+			if i == 2 && len(d.conns) != 1 {
+				t.Fatal("connection not tracked")
+			}
+		}
+		if len(d.conns) != 0 || len(d.order) != 0 {
+			t.Errorf("%d connections left after teardown", len(d.conns))
+		}
+	}
+}
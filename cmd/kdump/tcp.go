@@ -0,0 +1,87 @@
+package main
+
+import "encoding/binary"
+
+// halfStream reassembles one direction of a TCP connection.
+type halfStream struct {
+	started bool
+	fin     bool
+	next    uint32
+	pending map[uint32][]byte
+
+	// buf holds reassembled bytes that have not been consumed yet.
+	buf []byte
+}
+
+func (h *halfStream) reset() {
+	*h = halfStream{}
+}
+
+func (h *halfStream) add(seq uint32, syn bool, data []byte) {
+	if syn {
+		h.reset()
+		h.started, h.next = true, seq+1
+		seq++
+	} else if !h.started {
+		// The capture began after the handshake; assume this segment starts
+		// at a frame boundary.
+		h.started, h.next = true, seq
+	}
+	if len(data) == 0 {
+		return
+	}
+	if int32(seq-h.next) > 0 {
+		if h.pending == nil {
+			h.pending = make(map[uint32][]byte)
+		}
+		h.pending[seq] = append([]byte(nil), data...)
+		return
+	}
+	h.append(seq, data)
+
+	for len(h.pending) > 0 {
+		var advanced bool
+		for seq, data := range h.pending {
+			if int32(seq-h.next) > 0 {
+				continue
+			}
+			delete(h.pending, seq)
+			h.append(seq, data)
+			advanced = true
+		}
+		if !advanced {
+			break
+		}
+	}
+}
+
+// append adds data starting at seq, which must not be after h.next, skipping
+// any bytes that were already received.
+func (h *halfStream) append(seq uint32, data []byte) {
+	skip := int(h.next - seq)
+	if skip >= len(data) {
+		return
+	}
+	data = data[skip:]
+	h.buf = append(h.buf, data...)
+	h.next += uint32(len(data))
+}
+
+// frame removes and returns the next complete Kafka frame, if any. It returns
+// an error if the stream does not look like Kafka framing.
+func (h *halfStream) frame() ([]byte, bool, error) {
+	if len(h.buf) < 4 {
+		return nil, false, nil
+	}
+	size := int32(binary.BigEndian.Uint32(h.buf))
+	if size < 0 {
+		return nil, false, errFrameSize
+	}
+	end := 4 + int(size)
+	if len(h.buf) < end {
+		return nil, false, nil
+	}
+	b := h.buf[4:end]
+	h.buf = h.buf[end:]
+	return b, true, nil
+}
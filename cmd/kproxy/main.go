@@ -0,0 +1,210 @@
+// Command kproxy is a logging Kafka proxy. It forwards every frame between a
+// client and a single broker untouched, and writes each decoded request and
+// response to stdout as a line of JSON.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/betawaffle/kafka-gen-go/kafkaproto"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:9092", "address to accept clients on")
+	broker := flag.String("broker", "", "address of the broker to forward to")
+	flag.Parse()
+
+	log.SetFlags(0)
+	if *broker == "" {
+		log.Fatal("kproxy: -broker is required")
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := &proxy{
+		broker: *broker,
+		out:    json.NewEncoder(os.Stdout),
+	}
+	p.out.SetEscapeHTML(false)
+	log.Fatal(p.serve(l))
+}
+
+type proxy struct {
+	broker string
+	conns  uint64
+
+	mu  sync.Mutex
+	out *json.Encoder
+}
+
+type entry struct {
+	Time          time.Time `json:"time"`
+	Conn          uint64    `json:"conn"`
+	Type          string    `json:"type"`
+	API           string    `json:"api,omitempty"`
+	APIKey        int16     `json:"api_key"`
+	APIVersion    int16     `json:"api_version"`
+	CorrelationID int32     `json:"correlation_id"`
+	LatencyMs     float64   `json:"latency_ms,omitempty"`
+
+	Header interface{} `json:"header,omitempty"`
+	Body   interface{} `json:"body,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func (p *proxy) log(e *entry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.out.Encode(e); err != nil {
+		log.Print(err)
+	}
+}
+
+func (p *proxy) serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(c, atomic.AddUint64(&p.conns, 1))
+	}
+}
+
+func (p *proxy) handle(client net.Conn, id uint64) {
+	defer client.Close()
+
+	broker, err := net.Dial("tcp", p.broker)
+	if err != nil {
+		log.Printf("conn %d: %v", id, err)
+		return
+	}
+	defer broker.Close()
+
+	s := &session{
+		proxy:   p,
+		id:      id,
+		pending: make(map[int32]pending),
+	}
+	go func() {
+		s.forwardResponses(broker, client)
+		client.Close()
+	}()
+	s.forwardRequests(client, broker)
+}
+
+type pending struct {
+	key     int16
+	version int16
+	sent    time.Time
+}
+
+type session struct {
+	*proxy
+	id uint64
+
+	mu      sync.Mutex
+	pending map[int32]pending
+}
+
+func (s *session) forwardRequests(src, dst net.Conn) {
+	var buf []byte
+	for {
+		b, err := kafkaproto.ReadFrame(src, buf)
+		if err != nil {
+			return
+		}
+		buf = b
+
+		now := time.Now()
+		e := &entry{Time: now, Conn: s.id, Type: "request"}
+		r, err := kafkaproto.DecodeRequest(b)
+		if r != nil {
+			e.APIKey, e.APIVersion, e.CorrelationID = r.APIKey, r.APIVersion, r.CorrelationID
+			if a := kafkaproto.LookupAPI(r.APIKey); a != nil {
+				e.API = a.Name
+			}
+			e.Header, e.Body = r.Header, kafkaproto.JSONValue(r.Body, r.APIVersion)
+
+			if err != nil || kafkaproto.ExpectsResponse(r.Body) {
+				s.mu.Lock()
+				s.pending[r.CorrelationID] = pending{key: r.APIKey, version: r.APIVersion, sent: now}
+				s.mu.Unlock()
+			}
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		s.log(e)
+		if r != nil {
+			r.Release()
+		}
+
+		if err = kafkaproto.WriteFrame(dst, b); err != nil {
+			return
+		}
+	}
+}
+
+func (s *session) forwardResponses(src, dst net.Conn) {
+	var buf []byte
+	for {
+		b, err := kafkaproto.ReadFrame(src, buf)
+		if err != nil {
+			return
+		}
+		buf = b
+
+		now := time.Now()
+		e := &entry{Time: now, Conn: s.id, Type: "response"}
+		var r *kafkaproto.Response
+		if corr, err := kafkaproto.ResponseCorrelationID(b); err != nil {
+			e.Error = err.Error()
+		} else {
+			s.mu.Lock()
+			req, ok := s.pending[corr]
+			delete(s.pending, corr)
+			s.mu.Unlock()
+
+			e.CorrelationID = corr
+			if ok {
+				r = s.decodeResponse(e, b, req)
+			} else {
+				e.Error = "no request with this correlation id"
+			}
+		}
+		s.log(e)
+		if r != nil {
+			r.Release()
+		}
+
+		if err = kafkaproto.WriteFrame(dst, b); err != nil {
+			return
+		}
+	}
+}
+
+func (s *session) decodeResponse(e *entry, b []byte, req pending) *kafkaproto.Response {
+	e.APIKey, e.APIVersion = req.key, req.version
+	e.LatencyMs = float64(e.Time.Sub(req.sent)) / float64(time.Millisecond)
+	if a := kafkaproto.LookupAPI(req.key); a != nil {
+		e.API = a.Name
+	}
+
+	r, err := kafkaproto.DecodeResponse(b, req.key, req.version)
+	if r != nil {
+		e.Header, e.Body = r.Header, kafkaproto.JSONValue(r.Body, req.version)
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,160 @@
+//go:build generated
+// +build generated
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/betawaffle/kafka-gen-go/kafkaproto"
+)
+
+// payload encodes int16, int32 and string fields the way the non-flexible
+// versions of the protocol do.
+func payload(fields ...interface{}) []byte {
+	var b []byte
+	for _, f := range fields {
+		switch f := f.(type) {
+		case int16:
+			b = append(b, 0, 0)
+			binary.BigEndian.PutUint16(b[len(b)-2:], uint16(f))
+		case int32:
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], uint32(f))
+		case string:
+			b = append(append(b, payload(int16(len(f)))...), f...)
+		}
+	}
+	return b
+}
+
+// mockBroker answers every request with a version 0 metadata response
+// naming itself as the only broker.
+func mockBroker(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			b, err := kafkaproto.ReadFrame(c, nil)
+			if err != nil {
+				return
+			}
+			corr := int32(binary.BigEndian.Uint32(b[4:]))
+			res := payload(corr, int32(1), int32(1), "broker", int32(9092), int32(0))
+			if err := kafkaproto.WriteFrame(c, res); err != nil {
+				return
+			}
+		}
+	}()
+	return l
+}
+
+func TestProxy(t *testing.T) {
+	bl := mockBroker(t)
+	defer bl.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var out bytes.Buffer
+	p := &proxy{broker: bl.Addr().String(), out: json.NewEncoder(&out)}
+	go p.serve(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// A version 0 metadata request for all topics.
+	if err := kafkaproto.WriteFrame(c, payload(int16(3), int16(0), int32(7), "test", int32(0))); err != nil {
+		t.Fatal(err)
+	}
+	b, err := kafkaproto.ReadFrame(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := payload(int32(7), int32(1), int32(1), "broker", int32(9092), int32(0)); !bytes.Equal(b, want) {
+		t.Fatalf("forwarded %x, want %x", b, want)
+	}
+
+	// The response is logged before it is forwarded.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var entries []entry
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		var e entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("logged %d entries", len(entries))
+	}
+	for i, typ := range []string{"request", "response"} {
+		e := entries[i]
+		if e.Type != typ || e.API != "Metadata" || e.APIVersion != 0 || e.CorrelationID != 7 || e.Error != "" {
+			t.Errorf("%s: %+v", typ, e)
+		}
+	}
+	b, _ = json.Marshal(entries[1].Body)
+	if !bytes.Contains(b, []byte(`"host":"broker"`)) {
+		t.Errorf("response body %s", b)
+	}
+}
+
+// requestFrame returns the payload of a request frame.
+func requestFrame(corr int32, body kafkaproto.Message, key, v int16) []byte {
+	h := new(kafkaproto.RequestHeader)
+	h.Reset()
+	h.RequestApiKey, h.RequestApiVersion, h.CorrelationId = key, v, corr
+	return body.(interface {
+		AppendTo([]byte, int16) []byte
+	}).AppendTo(h.AppendTo(nil, 1), v)
+}
+
+func TestProxyUnansweredRequests(t *testing.T) {
+	s := &session{
+		proxy:   &proxy{out: json.NewEncoder(ioutil.Discard)},
+		pending: make(map[int32]pending),
+	}
+	client, src := net.Pipe()
+	dst, broker := net.Pipe()
+	defer client.Close()
+	defer broker.Close()
+	go s.forwardRequests(src, dst)
+
+	for i, acks := range []int16{0, 1, 0} {
+		req := new(kafkaproto.ProduceRequest)
+		req.Reset()
+		req.Acks = acks
+		go kafkaproto.WriteFrame(client, requestFrame(int32(i), req, 0, 3))
+		if _, err := kafkaproto.ReadFrame(broker, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[1]; !ok || len(s.pending) != 1 {
+		t.Fatalf("pending %v", s.pending)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"go/token"
+	"strings"
+
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+// genCollection writes the collection type used for arrays of the struct
+// name, which has the map keys keys.
+func genCollection(w *codegen.File, name string, keys []*schema.Field) {
+	coll := name + "Collection"
+	key := name + "Key"
+
+	for _, k := range keys {
+		if k.Type.Array || isStructType(k.Type.Elem) || hasCompactForm(k.Type.Elem) && k.Type.Elem != "string" ||
+			k.Type.Elem == "string" && isNullable(k) {
+			panic("unsupported map key " + name + "." + k.Name)
+		}
+	}
+
+	w.WriteString("type ")
+	w.WriteString(coll)
+	w.WriteString(" []")
+	w.WriteString(name)
+	w.WriteString("\n\n")
+
+	if len(keys) > 1 {
+		w.WriteString("type ")
+		w.WriteString(key)
+		w.WriteString(" struct {\n")
+		for _, k := range keys {
+			w.WriteString(k.Name)
+			w.WriteByte(' ')
+			genFieldType(w, k)
+			w.WriteByte('\n')
+		}
+		w.WriteString("}\n\n")
+	} else {
+		key = fieldType(keys[0])
+	}
+
+	w.WriteString("func (c ")
+	w.WriteString(coll)
+	w.WriteString(") Find(")
+	for i, k := range keys {
+		if i != 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString(keyParam(k))
+		w.WriteByte(' ')
+		genFieldType(w, k)
+	}
+	w.WriteString(") *")
+	w.WriteString(name)
+	w.WriteString(" {\nfor i := range c {\nif ")
+	for i, k := range keys {
+		if i != 0 {
+			w.WriteString(" && ")
+		}
+		w.WriteString("c[i].")
+		w.WriteString(k.Name)
+		w.WriteString(" == ")
+		w.WriteString(keyParam(k))
+	}
+	w.WriteString(" {\nreturn &c[i]\n}\n}\nreturn nil\n}\n\n")
+
+	w.WriteString("func (c ")
+	w.WriteString(coll)
+	w.WriteString(") Index() map[")
+	w.WriteString(key)
+	w.WriteString("]*")
+	w.WriteString(name)
+	w.WriteString(" {\nx := make(map[")
+	w.WriteString(key)
+	w.WriteString("]*")
+	w.WriteString(name)
+	w.WriteString(", len(c))\nfor i := range c {\nx[")
+	genCollectionKey(w, name, keys)
+	w.WriteString("] = &c[i]\n}\nreturn x\n}\n\n")
+
+	w.WriteString("func (c ")
+	w.WriteString(coll)
+	w.WriteString(") hasDuplicates() bool {\nif len(c) < 2 {\nreturn false\n}\n")
+	w.WriteString("x := make(map[")
+	w.WriteString(key)
+	w.WriteString("]struct{}, len(c))\nfor i := range c {\nk := ")
+	genCollectionKey(w, name, keys)
+	w.WriteString("\nif _, ok := x[k]; ok {\nreturn true\n}\nx[k] = struct{}{}\n}\nreturn false\n}\n\n")
+}
+
+func genCollectionKey(w *codegen.File, name string, keys []*schema.Field) {
+	if len(keys) == 1 {
+		w.WriteString("c[i].")
+		w.WriteString(keys[0].Name)
+		return
+	}
+	w.WriteString(name)
+	w.WriteString("Key{")
+	for i, k := range keys {
+		if i != 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString("c[i].")
+		w.WriteString(k.Name)
+	}
+	w.WriteByte('}')
+}
+
+// genDuplicateCheck writes a check that the keys of a freshly decoded array
+// field in a are unique, in the versions where all of them are present. Only
+// strict decoding checks, since brokers answer each duplicate entry.
+func genDuplicateCheck(w *codegen.File, f *schema.Field) {
+	w.WriteString("if d.opts.strict() && ")
+	for _, k := range f.KeyFields {
+		if versionsCover(k.Versions, f.Versions) {
+			continue
+		}
+		genVersionCond(w, k.Versions, f.Versions)
+		w.WriteString(" && ")
+	}
+	w.WriteString("a.hasDuplicates() {\npanic(errDuplicateKey)\n}\n")
+}
+
+func keyParam(k *schema.Field) string {
+	s := strings.ToLower(k.Name[:1]) + k.Name[1:]
+	if token.IsKeyword(s) {
+		s += "_"
+	}
+	return s
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+func genFieldDropAbsent(w *codegen.File, m *schema.MessageData, f *schema.Field) {
+	t := &f.Type
+
+	present := func() {
+		if !isStructType(t.Elem) {
+			return
+		}
+		if t.Array {
+			w.WriteString("for i := range m.")
+			w.WriteString(f.Name)
+			w.WriteString(" {\nm.")
+			w.WriteString(f.Name)
+			w.WriteString("[i].dropAbsent(v)\n}\n")
+		} else {
+			w.WriteString("m.")
+			w.WriteString(f.Name)
+			w.WriteString(".dropAbsent(v)\n")
+		}
+	}
+	absent := func() {
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(" = ")
+		genFieldDefault(w, f)
+		w.WriteByte('\n')
+	}
+
+	switch {
+	case versionsCover(f.Versions, m.ValidVersions):
+		present()
+	case !versionsOverlap(f.Versions, m.ValidVersions):
+		absent()
+	case isStructType(t.Elem):
+		w.WriteString("if ")
+		genVersionCond(w, f.Versions, m.ValidVersions)
+		w.WriteString(" {\n")
+		present()
+		w.WriteString("} else {\n")
+		absent()
+		w.WriteString("}\n")
+	default:
+		w.WriteString("if !(")
+		genVersionCond(w, f.Versions, m.ValidVersions)
+		w.WriteString(") {\n")
+		absent()
+		w.WriteString("}\n")
+	}
+}
+
+// genMessageConvert writes ConvertTo, which returns a copy of the message
+// without the fields that version v lacks, along with the ignorable fields
+// that were dropped. It fails if any dropped field is not ignorable.
+func genMessageConvert(w *codegen.File, m *schema.MessageData) {
+	begMethod(w, m.Name, "ConvertTo", "v int16", "(*"+m.Name+", []*FieldError, error)")
+	w.WriteString("dropped, err := checkConversion(m, ")
+	w.WriteQuoted(m.Name)
+	w.WriteString(", v)\nif err != nil {\nreturn nil, dropped, err\n}\n")
+	w.WriteString("c := m.Clone()\nc.dropAbsent(v)\nreturn c, dropped, nil\n")
+	endMethod(w)
+}
+
+func genStructDropAbsent(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "dropAbsent", "v int16", "")
+
+	for _, f := range fields {
+		genFieldDropAbsent(w, m, f)
+	}
+
+	endMethod(w)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+func genFieldEqual(w *codegen.File, m *schema.MessageData, f *schema.Field) {
+	t := &f.Type
+
+	// A negative version compares the fields of every version.
+	scoped := !versionsCover(f.Versions, m.ValidVersions)
+	if scoped {
+		w.WriteString("if v < 0 || ")
+		genVersionCond(w, f.Versions, m.ValidVersions)
+		w.WriteString(" {\n")
+	}
+
+	w.WriteString("if ")
+	switch {
+	case t.Array:
+		if isNullable(f) {
+			genNilMismatch(w, f)
+			w.WriteString(" || ")
+		}
+		w.WriteString("len(m.")
+		w.WriteString(f.Name)
+		w.WriteString(") != len(o.")
+		w.WriteString(f.Name)
+		w.WriteString(") {\nreturn false\n}\nfor i := range m.")
+		w.WriteString(f.Name)
+		w.WriteString(" {\nif ")
+		genValueNotEqual(w, t.Elem, f.Name+"[i]")
+		w.WriteString(" {\nreturn false\n}\n}\n")
+	case t.Elem == "bytes" || t.Elem == "records":
+		if isNullable(f) {
+			genNilMismatch(w, f)
+			w.WriteString(" || ")
+		}
+		genValueNotEqual(w, t.Elem, f.Name)
+		w.WriteString(" {\nreturn false\n}\n")
+	case t.Elem == "string" && isNullable(f):
+		w.WriteString("!equalNullableString(")
+		w.WriteString(baseValue(f, "m."+f.Name))
+		w.WriteString(", ")
+		w.WriteString(baseValue(f, "o."+f.Name))
+		w.WriteString(") {\nreturn false\n}\n")
+	default:
+		genValueNotEqual(w, t.Elem, f.Name)
+		w.WriteString(" {\nreturn false\n}\n")
+	}
+
+	if scoped {
+		w.WriteString("}\n")
+	}
+}
+
+// genNilMismatch writes a condition that is true when exactly one of the two
+// values is null in a version where that can be encoded.
+func genNilMismatch(w *codegen.File, f *schema.Field) {
+	w.WriteString("(m.")
+	w.WriteString(f.Name)
+	w.WriteString(" == nil) != (o.")
+	w.WriteString(f.Name)
+	w.WriteString(" == nil)")
+	if !versionsCover(f.NullableVersions, f.Versions) {
+		w.WriteString(" && (v < 0 || ")
+		genVersionCond(w, f.NullableVersions, f.Versions)
+		w.WriteByte(')')
+	}
+}
+
+func genStructEqual(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "Equal", "o *"+recv, "bool")
+	w.WriteString("return m.equal(o, -1)\n")
+	endMethod(w)
+
+	begMethod(w, recv, "EqualVersion", "o *"+recv+", v int16", "bool")
+	w.WriteString("return m.equal(o, v)\n")
+	endMethod(w)
+
+	begMethod(w, recv, "equal", "o *"+recv+", v int16", "bool")
+	w.WriteString("if m == nil || o == nil {\nreturn m == o\n}\n")
+
+	for _, f := range fields {
+		genFieldEqual(w, m, f)
+	}
+
+	w.WriteString("return true\n")
+	endMethod(w)
+}
+
+func genValueNotEqual(w *codegen.File, t, name string) {
+	switch {
+	case t == "bytes" || t == "records":
+		w.WriteString("!equalBytes(m.")
+		w.WriteString(name)
+		w.WriteString(", o.")
+		w.WriteString(name)
+		w.WriteByte(')')
+	case isStructType(t):
+		w.WriteString("!m.")
+		w.WriteString(name)
+		w.WriteString(".equal(&o.")
+		w.WriteString(name)
+		w.WriteString(", v)")
+	default:
+		w.WriteString("m.")
+		w.WriteString(name)
+		w.WriteString(" != o.")
+		w.WriteString(name)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+func genFieldFormat(w *codegen.File, f *schema.Field) {
+	t := &f.Type
+
+	w.WriteString("if ")
+	genFieldNonDefault(w, f)
+	w.WriteString(" {\np.field(")
+	w.WriteQuoted(f.Name)
+	w.WriteString(")\n")
+
+	if t.Array {
+		w.WriteString("p.open('[')\nfor i := range m.")
+		w.WriteString(f.Name)
+		w.WriteString(" {\np.elem()\n")
+		genFormatValue(w, t.Elem, baseValue(f, "m."+f.Name+"[i]"), false)
+		w.WriteString("}\np.close(']')\n")
+	} else {
+		genFormatValue(w, t.Elem, baseValue(f, "m."+f.Name), isNullable(f))
+	}
+
+	w.WriteString("}\n")
+}
+
+func genFormatValue(w *codegen.File, t, val string, nullable bool) {
+	switch t {
+	case "bool", "boolean":
+		w.WriteString("p.writeBool(")
+	case "int8", "int16", "int32":
+		w.WriteString("p.writeInt(int64(")
+		w.WriteString(val)
+		w.WriteString("))\n")
+		return
+	case "int64":
+		w.WriteString("p.writeInt(")
+	case "string":
+		if nullable {
+			w.WriteString("p.writeNullableString(")
+		} else {
+			w.WriteString("p.writeString(")
+		}
+	case "bytes", "records":
+		w.WriteString("p.writeBytes(")
+	default:
+		if !isStructType(t) {
+			panic("no formatter for " + t)
+		}
+		w.WriteString(val)
+		w.WriteString(".format(p)\n")
+		return
+	}
+	w.WriteString(val)
+	w.WriteString(")\n")
+}
+
+func genStructFormat(w *codegen.File, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "Format", "f fmt.State, c rune", "")
+	w.WriteString("formatMessage(f, c, ")
+	w.WriteQuoted(recv)
+	w.WriteString(", m)\n")
+	endMethod(w)
+
+	begMethod(w, recv, "String", "", "string")
+	w.WriteString("return formatString(")
+	w.WriteQuoted(recv)
+	w.WriteString(", m)\n")
+	endMethod(w)
+
+	begMethod(w, recv, "format", "p *printer", "")
+	w.WriteString("p.open('{')\n")
+
+	for _, f := range fields {
+		genFieldFormat(w, f)
+	}
+
+	w.WriteString("p.close('}')\n")
+	endMethod(w)
+}
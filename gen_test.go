@@ -0,0 +1,123 @@
+//go:build !generated
+// +build !generated
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// genModes are the ways the runtime is generated for testing. Each mode
+// generates the schemas in testdata/schemas into a copy of the module, and
+// runs the tests of the copy built with the generated tag and its own. The
+// generated benchmarks compare the modes, run in each copy with -bench. The
+// schemas in a mode's overlay directory replace those of the same name.
+var genModes = []struct {
+	name    string
+	flags   []string
+	tags    string
+	overlay string
+}{
+	{"default", nil, "", ""},
+	{"zerocopy", []string{"-zerocopy"}, "", ""},
+	{"copybytes", []string{"-copybytes"}, "", ""},
+	{"tables", []string{"-tables"}, "", ""},
+	{"inline", []string{"-inline", "0,1,3,18,1000"}, "", ""},
+	{"tables-inline", []string{"-tables", "-inline", "0,1"}, "", ""},
+	{"lazy", nil, "lazy", "testdata/lazy"},
+}
+
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generating and testing the runtime takes a while")
+	}
+	schemas, err := filepath.Glob("testdata/schemas/*.json")
+	if err != nil || len(schemas) == 0 {
+		t.Fatal("no schemas in testdata/schemas", err)
+	}
+	for _, mode := range genModes {
+		mode := mode
+		t.Run(mode.name, func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "kafka-gen-go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			copyModule(t, dir)
+			if mode.overlay != "" {
+				overlay, _ := filepath.Glob(filepath.Join(mode.overlay, "*.json"))
+				for _, path := range overlay {
+					if err := copyFile(filepath.Join(dir, "testdata/schemas", filepath.Base(path)), path); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			args := append([]string{"run", "."}, mode.flags...)
+			args = append(args, "-bench", "kafkaproto")
+			args = append(args, schemas...)
+			goCommand(t, dir, args...)
+
+			tags := strings.TrimSpace("generated " + mode.tags)
+			goCommand(t, dir, "vet", "-tags", tags, "./...")
+			// Running each benchmark once checks its round trip.
+			goCommand(t, dir, "test", "-tags", tags, "-bench", ".", "-benchtime", "1x", "./...")
+		})
+	}
+}
+
+// copyModule copies the module to dir, leaving out git metadata and anything
+// generated into the runtime package.
+func copyModule(t *testing.T, dir string) {
+	err := filepath.Walk(".", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if fi.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dir, path), 0755)
+		}
+		if strings.HasSuffix(path, "_gen.go") || strings.HasSuffix(path, "_gen_test.go") {
+			return nil
+		}
+		return copyFile(filepath.Join(dir, path), path)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func goCommand(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args[:2], " "), err, out)
+	}
+}
@@ -39,7 +39,27 @@ func (g *hdrGenerator) getFileName() string {
 	return "headers_gen.go"
 }
 
+func (g *hdrGenerator) getMessages() []*schema.MessageData {
+	return []*schema.MessageData{g.req, g.res}
+}
+
 func (g *hdrGenerator) run(w *codegen.File) {
+	w.WriteString("import (\n\"fmt\"\n\"sync\"\n")
+	if usesTables(g.req, g.res) {
+		w.WriteString("\"unsafe\"\n")
+	}
+	w.WriteString(")\n\n")
+
 	genMessage(w, g.req)
 	genMessage(w, g.res)
+	genStreamWhole(w, g.res)
+
+	begMethod(w, g.req.Name, "setRequest", "key, v int16, corr int32, clientID *string", "")
+	w.WriteString("m.Reset()\nm.RequestApiKey = key\nm.RequestApiVersion = v\n")
+	w.WriteString("m.CorrelationId = corr\nm.ClientId = clientID\n")
+	endMethod(w)
+
+	begMethod(w, g.res.Name, "setCorrelationID", "id int32", "")
+	w.WriteString("m.CorrelationId = id\n")
+	endMethod(w)
 }
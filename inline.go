@@ -0,0 +1,381 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+// apiKeySet is a flag holding a comma-separated list of api keys.
+type apiKeySet map[int16]bool
+
+func (s apiKeySet) String() string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, strconv.Itoa(int(k)))
+	}
+	return strings.Join(keys, ",")
+}
+
+func (s apiKeySet) Set(v string) error {
+	for _, k := range strings.Split(v, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(k), 10, 16)
+		if err != nil {
+			return err
+		}
+		s[int16(n)] = true
+	}
+	return nil
+}
+
+func isInlined(m *schema.MessageData) bool {
+	return m.ApiKey != nil && inline[*m.ApiKey]
+}
+
+// genStructInline writes decode, encode and size methods that handle each
+// field in line, with one switch arm for each group of versions sharing an
+// encoding, so that version checks are made once per struct.
+func genStructInline(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	arms := inlineArms(m, fields)
+
+	begMethod(w, recv, "decode", "d *decoder, v int16", "")
+	w.WriteString("d.enter()\nm.clear()\n")
+	genInlineSwitch(w, arms, func(v int16) {
+		genInlineDecode(w, m, fields, v)
+	})
+	w.WriteString("d.leave()\n")
+	endMethod(w)
+
+	begMethod(w, recv, "encode", "e *encoder, v int16", "")
+	genInlineSwitch(w, arms, func(v int16) {
+		genInlineEncode(w, m, fields, v)
+	})
+	endMethod(w)
+
+	begMethod(w, recv, "size", "v int16", "int")
+	w.WriteString("var s int\n")
+	genInlineSwitch(w, arms, func(v int16) {
+		genInlineSize(w, m, fields, v)
+	})
+	w.WriteString("return s\n")
+	endMethod(w)
+}
+
+// inlineArms groups the valid versions of m by how fields are encoded in
+// them.
+func inlineArms(m *schema.MessageData, fields []*schema.Field) [][]int16 {
+	var arms [][]int16
+	index := make(map[string]int)
+
+	max := m.ValidVersions.Max
+	for v := m.ValidVersions.Min; v <= max; v++ {
+		var b strings.Builder
+		b.WriteString(strconv.FormatBool(inVersions(m.FlexibleVersions, v)))
+		for _, f := range fields {
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatBool(inVersions(f.Versions, v)))
+			b.WriteString(strconv.FormatBool(inVersions(flexibleVersions(m, f), v)))
+			b.WriteString(strconv.FormatBool(inVersions(f.NullableVersions, v)))
+			b.WriteString(strconv.FormatBool(inVersions(f.TaggedVersions, v)))
+			b.WriteString(strconv.FormatBool(keysPresent(f, v)))
+		}
+		k := b.String()
+		if i, ok := index[k]; ok {
+			arms[i] = append(arms[i], v)
+			continue
+		}
+		index[k] = len(arms)
+		arms = append(arms, []int16{v})
+	}
+	return arms
+}
+
+// genInlineSwitch calls fn with the first version of each arm, inside a switch
+// on the version if there is more than one.
+func genInlineSwitch(w *codegen.File, arms [][]int16, fn func(v int16)) {
+	if len(arms) == 1 {
+		fn(arms[0][0])
+		return
+	}
+	w.WriteString("switch v {\n")
+	for _, arm := range arms {
+		w.WriteString("case ")
+		for i, v := range arm {
+			if i != 0 {
+				w.WriteString(", ")
+			}
+			w.WriteInt(int64(v), 10)
+		}
+		w.WriteString(":\n")
+		fn(arm[0])
+	}
+	w.WriteString("}\n")
+}
+
+func genInlineDecode(w *codegen.File, m *schema.MessageData, fields []*schema.Field, v int16) {
+	var tagged bool
+	for _, f := range fields {
+		if isTagged(f) {
+			tagged = tagged || inVersions(f.Versions, v)
+			continue
+		}
+		genInlineFieldDecode(w, m, f, v)
+	}
+	if !inVersions(m.FlexibleVersions, v) {
+		return
+	}
+	if !tagged {
+		w.WriteString("d.skipUnknownTaggedFields()\n")
+		return
+	}
+	w.WriteString("for n := d.decodeTaggedFieldCount(); n > 0; n-- {\n")
+	w.WriteString("t := d.decodeTag()\n")
+	w.WriteString("b := d.decodeTaggedField()\n")
+	w.WriteString("switch t {\n")
+	for _, f := range fields {
+		if !isTagged(f) || !inVersions(f.Versions, v) {
+			continue
+		}
+		w.WriteString("case ")
+		w.WriteInt(int64(*f.Tag), 10)
+		w.WriteString(":\nd := &b\n")
+		genInlineFieldDecode(w, m, f, v)
+		w.WriteString("d.taggedFieldDone()\n")
+	}
+	w.WriteString("default:\nb.unknownTag(t)\n}\n}\n")
+}
+
+func genInlineFieldDecode(w *codegen.File, m *schema.MessageData, f *schema.Field, v int16) {
+	t := &f.Type
+	if !inVersions(f.Versions, v) {
+		return
+	}
+	compact := inVersions(flexibleVersions(m, f), v)
+
+	if !t.Array {
+		nullable := isNullable(f)
+		if t.Elem != "string" {
+			nullable = inVersions(f.NullableVersions, v)
+		}
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		genAssignFromDecoder(w, t.Elem, decodeConv(f), compact, nullable)
+		return
+	}
+
+	w.WriteString("if n := d.decode")
+	if compact {
+		w.WriteString("Compact")
+	}
+	w.WriteString("ArrayLen(); n < 0 {\n")
+	if isNullable(f) {
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(" = nil\n")
+	} else {
+		w.WriteString("panic(errMalformed)\n")
+	}
+	// Reuse the capacity, and the elements, left by an earlier decode.
+	w.WriteString("} else {\na := m.")
+	w.WriteString(f.Name)
+	w.WriteString("\nif cap(a) < n {\na = make(")
+	genFieldType(w, f)
+	w.WriteString(", n)\n} else {\na = a[:n]\n}\nfor i := range a {\na[i]")
+	genAssignFromDecoder(w, t.Elem, decodeConv(f), compact && hasCompactForm(t.Elem), false)
+	w.WriteString("}\n")
+	if keysPresent(f, v) {
+		w.WriteString("if d.opts.strict() && a.hasDuplicates() {\npanic(errDuplicateKey)\n}\n")
+	}
+	w.WriteString("m.")
+	w.WriteString(f.Name)
+	w.WriteString(" = a\n}\n")
+}
+
+func genInlineEncode(w *codegen.File, m *schema.MessageData, fields []*schema.Field, v int16) {
+	var tagged []*schema.Field
+	for _, f := range fields {
+		if isTagged(f) {
+			if inVersions(f.TaggedVersions, v) && inVersions(f.Versions, v) {
+				tagged = append(tagged, f)
+			}
+			continue
+		}
+		genInlineFieldEncode(w, m, f, v)
+	}
+	if !inVersions(m.FlexibleVersions, v) {
+		return
+	}
+	if len(tagged) == 0 {
+		w.WriteString("e.encodeUvarint(0)\n")
+		return
+	}
+	w.WriteString("var n uint64\n")
+	for _, f := range tagged {
+		w.WriteString("if ")
+		genFieldNonDefault(w, f)
+		w.WriteString(" {\nn++\n}\n")
+	}
+	w.WriteString("e.encodeUvarint(n)\n")
+	for _, f := range tagged {
+		w.WriteString("if ")
+		genFieldNonDefault(w, f)
+		w.WriteString(" {\ne.encodeUvarint(")
+		w.WriteInt(int64(*f.Tag), 10)
+		w.WriteString(")\nvar s int\n")
+		genInlineFieldSize(w, m, f, v)
+		w.WriteString("e.encodeUvarint(uint64(s))\n")
+		genInlineFieldEncode(w, m, f, v)
+		w.WriteString("}\n")
+	}
+}
+
+func genInlineFieldEncode(w *codegen.File, m *schema.MessageData, f *schema.Field, v int16) {
+	t := &f.Type
+	if !inVersions(f.Versions, v) {
+		return
+	}
+	compact := inVersions(flexibleVersions(m, f), v)
+
+	if !t.Array {
+		nullable := isNullable(f)
+		if t.Elem != "string" {
+			nullable = inVersions(f.NullableVersions, v)
+		}
+		genEncodeValue(w, t.Elem, baseValue(f, "m."+f.Name), compact, nullable)
+		return
+	}
+
+	arrayLen := "e.encodeArrayLen("
+	if compact {
+		arrayLen = "e.encodeCompactArrayLen("
+	}
+	if inVersions(f.NullableVersions, v) {
+		w.WriteString("if m.")
+		w.WriteString(f.Name)
+		w.WriteString(" == nil {\n")
+		w.WriteString(arrayLen)
+		w.WriteString("-1)\n} else {\n")
+		w.WriteString(arrayLen)
+		w.WriteString("len(m.")
+		w.WriteString(f.Name)
+		w.WriteString("))\n}\n")
+	} else {
+		w.WriteString(arrayLen)
+		w.WriteString("len(m.")
+		w.WriteString(f.Name)
+		w.WriteString("))\n")
+	}
+	w.WriteString("for i := range m.")
+	w.WriteString(f.Name)
+	w.WriteString(" {\n")
+	genEncodeValue(w, t.Elem, baseValue(f, "m."+f.Name+"[i]"), compact && hasCompactForm(t.Elem), false)
+	w.WriteString("}\n")
+}
+
+func genInlineSize(w *codegen.File, m *schema.MessageData, fields []*schema.Field, v int16) {
+	var tagged []*schema.Field
+	for _, f := range fields {
+		if isTagged(f) {
+			if inVersions(f.TaggedVersions, v) && inVersions(f.Versions, v) {
+				tagged = append(tagged, f)
+			}
+			continue
+		}
+		genInlineFieldSize(w, m, f, v)
+	}
+	if !inVersions(m.FlexibleVersions, v) {
+		return
+	}
+	if len(tagged) == 0 {
+		w.WriteString("s++\n")
+		return
+	}
+	w.WriteString("var n uint64\n")
+	for _, f := range tagged {
+		w.WriteString("if ")
+		genFieldNonDefault(w, f)
+		w.WriteString(" {\nt := s\n")
+		genInlineFieldSize(w, m, f, v)
+		w.WriteString("s += sizeUvarint(")
+		w.WriteInt(int64(*f.Tag), 10)
+		w.WriteString(") + sizeUvarint(uint64(s-t))\nn++\n}\n")
+	}
+	w.WriteString("s += sizeUvarint(n)\n")
+}
+
+// genInlineFieldSize writes statements adding the size of f at version v to
+// s.
+func genInlineFieldSize(w *codegen.File, m *schema.MessageData, f *schema.Field, v int16) {
+	t := &f.Type
+	if !inVersions(f.Versions, v) {
+		return
+	}
+	compact := inVersions(flexibleVersions(m, f), v)
+
+	if !t.Array {
+		nullable := isNullable(f)
+		if t.Elem != "string" {
+			nullable = inVersions(f.NullableVersions, v)
+		}
+		w.WriteString("s += ")
+		genSizeValue(w, t.Elem, baseValue(f, "m."+f.Name), compact, nullable)
+		w.WriteByte('\n')
+		return
+	}
+
+	switch {
+	case !compact:
+		w.WriteString("s += 4\n")
+	case inVersions(f.NullableVersions, v):
+		w.WriteString("if m.")
+		w.WriteString(f.Name)
+		w.WriteString(" == nil {\ns++\n} else {\ns += sizeUvarint(uint64(len(m.")
+		w.WriteString(f.Name)
+		w.WriteString(") + 1))\n}\n")
+	default:
+		w.WriteString("s += sizeUvarint(uint64(len(m.")
+		w.WriteString(f.Name)
+		w.WriteString(") + 1))\n")
+	}
+
+	switch t.Elem {
+	case "bool", "boolean", "int8":
+		w.WriteString("s += len(m.")
+	case "int16":
+		w.WriteString("s += 2 * len(m.")
+	case "int32":
+		w.WriteString("s += 4 * len(m.")
+	case "int64":
+		w.WriteString("s += 8 * len(m.")
+	default:
+		w.WriteString("for i := range m.")
+		w.WriteString(f.Name)
+		w.WriteString(" {\ns += ")
+		genSizeValue(w, t.Elem, baseValue(f, "m."+f.Name+"[i]"), compact && hasCompactForm(t.Elem), false)
+		w.WriteString("\n}\n")
+		return
+	}
+	w.WriteString(f.Name)
+	w.WriteString(")\n")
+}
+
+// inVersions reports whether v is in r.
+func inVersions(r *schema.VersionRange, v int16) bool {
+	return r != nil && r.Min != -1 && v >= r.Min && (r.Max == -1 || v <= r.Max)
+}
+
+// keysPresent reports whether the elements of f are checked for duplicate
+// keys at version v.
+func keysPresent(f *schema.Field, v int16) bool {
+	if len(f.KeyFields) == 0 {
+		return false
+	}
+	for _, k := range f.KeyFields {
+		if !inVersions(k.Versions, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+func genFieldMarshalJSON(w *codegen.File, m *schema.MessageData, recv string, f *schema.Field) {
+	present := func() {
+		if !isTagged(f) {
+			genJSONWriteField(w, f)
+			return
+		}
+		genVersionBranch(w, f.TaggedVersions, f.Versions, func(tagged bool) {
+			if !tagged {
+				genJSONWriteField(w, f)
+				return
+			}
+			w.WriteString("if ")
+			genFieldNonDefault(w, f)
+			w.WriteString(" {\n")
+			genJSONWriteField(w, f)
+			w.WriteString("}\n")
+		})
+	}
+
+	// Kafka refuses to silently drop a non-ignorable field that was set.
+	checked := !f.Ignorable && (f.Type.Array || f.ViewOf != nil || !isStructType(f.Type.Elem))
+	absent := func() {
+		w.WriteString("if ")
+		genFieldNonDefault(w, f)
+		w.WriteString(" {\nreturn jsonNonDefaultError(")
+		w.WriteQuoted(recv)
+		w.WriteString(", ")
+		w.WriteQuoted(jsonName(f))
+		w.WriteString(", v)\n}\n")
+	}
+
+	switch {
+	case versionsCover(f.Versions, m.ValidVersions):
+		present()
+	case !versionsOverlap(f.Versions, m.ValidVersions):
+		if checked {
+			absent()
+		}
+	default:
+		w.WriteString("if ")
+		genVersionCond(w, f.Versions, m.ValidVersions)
+		w.WriteString(" {\n")
+		present()
+		if checked {
+			w.WriteString("} else ")
+			absent()
+		} else {
+			w.WriteString("}\n")
+		}
+	}
+}
+
+func genFieldUnmarshalJSON(w *codegen.File, m *schema.MessageData, recv string, f *schema.Field) {
+	t := &f.Type
+
+	w.WriteString("if x, ok := o[")
+	w.WriteQuoted(jsonName(f))
+	w.WriteString("]; ok {\n")
+	genJSONNullCheck(w, recv, f)
+
+	if t.Array {
+		w.WriteString("a, err := jsonArray(x)\n")
+		genJSONCheck(w, recv, f, "err")
+		w.WriteString("if a != nil {\nm.")
+		w.WriteString(f.Name)
+		w.WriteString(" = make(")
+		genFieldType(w, f)
+		w.WriteString(", len(a))\nfor i, x := range a {\n")
+		genJSONRead(w, recv, f, t.Elem, entityType(f), false, "m."+f.Name+"[i]")
+		w.WriteString("}\n}\n")
+	} else {
+		genJSONRead(w, recv, f, t.Elem, entityConv(f), isNullable(f), "m."+f.Name)
+	}
+
+	// Fields that are tagged in some versions may be omitted in those.
+	mandatory := f.Versions
+	if isTagged(f) {
+		if versionsCover(f.TaggedVersions, f.Versions) {
+			mandatory = nil
+		}
+	}
+
+	switch {
+	case mandatory == nil || !versionsOverlap(mandatory, m.ValidVersions):
+		w.WriteString("}\n")
+		return
+	case versionsCover(mandatory, m.ValidVersions) && !isTagged(f):
+		w.WriteString("} else {\n")
+	default:
+		w.WriteString("} else if ")
+		genVersionCond(w, mandatory, m.ValidVersions)
+		if isTagged(f) {
+			w.WriteString(" && !(")
+			genVersionCond(w, f.TaggedVersions, f.Versions)
+			w.WriteByte(')')
+		}
+		w.WriteString(" {\n")
+	}
+	w.WriteString("return jsonMissingError(")
+	w.WriteQuoted(recv)
+	w.WriteString(", ")
+	w.WriteQuoted(jsonName(f))
+	w.WriteString(", v)\n}\n")
+}
+
+// genJSONNullCheck rejects a null x at the versions where f cannot be null,
+// for the types whose readers accept null.
+func genJSONNullCheck(w *codegen.File, recv string, f *schema.Field) {
+	n := f
+	if f.ViewOf != nil {
+		n = f.ViewOf
+	}
+	switch {
+	case f.ViewOf != nil, f.Type.Array, f.Type.Elem == "bytes", f.Type.Elem == "records":
+	case f.Type.Elem == "string" && isNullable(f):
+	default:
+		return
+	}
+	if versionsCover(n.NullableVersions, n.Versions) {
+		return
+	}
+	w.WriteString("if x == nil")
+	if isNullable(n) {
+		w.WriteString(" && !(")
+		genVersionCond(w, n.NullableVersions, n.Versions)
+		w.WriteByte(')')
+	}
+	w.WriteString(" {\nreturn jsonFieldError(")
+	w.WriteQuoted(recv)
+	w.WriteString(", ")
+	w.WriteQuoted(jsonName(f))
+	w.WriteString(", errJSONNull)\n}\n")
+}
+
+func genJSONCheck(w *codegen.File, recv string, f *schema.Field, err string) {
+	w.WriteString("if ")
+	w.WriteString(err)
+	w.WriteString(" != nil {\nreturn jsonFieldError(")
+	w.WriteQuoted(recv)
+	w.WriteString(", ")
+	w.WriteQuoted(jsonName(f))
+	w.WriteString(", err)\n}\n")
+}
+
+// genJSONRead reads the JSON value x into dst, converting it with conv if set.
+func genJSONRead(w *codegen.File, recv string, f *schema.Field, t, conv string, nullable bool, dst string) {
+	if isStructType(t) {
+		if f.ViewOf != nil {
+			w.WriteString("y, err := jsonArray(x)\n")
+		} else {
+			w.WriteString("y, err := jsonObject(x)\n")
+		}
+		genJSONCheck(w, recv, f, "err")
+		w.WriteString("if err := ")
+		w.WriteString(dst)
+		w.WriteString(".unmarshalJSON(y, v); err != nil {\nreturn jsonFieldError(")
+		w.WriteQuoted(recv)
+		w.WriteString(", ")
+		w.WriteQuoted(jsonName(f))
+		w.WriteString(", err)\n}\n")
+		return
+	}
+
+	switch t {
+	case "bool", "boolean":
+		w.WriteString("y, err := jsonBool(x)\n")
+	case "int8", "int16", "int32", "int64":
+		w.WriteString("y, err := jsonInt(x, ")
+		w.WriteString(t[3:])
+		w.WriteString(")\n")
+		if t != "int64" && conv == "" {
+			conv = t
+		}
+	case "string":
+		if nullable {
+			w.WriteString("y, err := jsonNullableString(x)\n")
+		} else {
+			w.WriteString("y, err := jsonString(x)\n")
+		}
+	case "bytes", "records":
+		w.WriteString("y, err := jsonBytes(x)\n")
+	default:
+		panic("no JSON reader for " + t)
+	}
+	genJSONCheck(w, recv, f, "err")
+
+	w.WriteString(dst)
+	w.WriteString(" = ")
+	if conv != "" {
+		w.WriteString(conv)
+		w.WriteString("(y)\n")
+	} else {
+		w.WriteString("y\n")
+	}
+}
+
+func genJSONWriteField(w *codegen.File, f *schema.Field) {
+	t := &f.Type
+
+	w.WriteString("w.name(")
+	w.WriteQuoted(jsonName(f))
+	w.WriteString(")\n")
+
+	// Null is only written at the versions where f is nullable, as on the
+	// wire; elsewhere it is written as empty.
+	val := baseValue(f, "m."+f.Name)
+	switch {
+	case t.Array:
+	case !isNullable(f):
+		genJSONWriteValue(w, t.Elem, val, false)
+		return
+	case t.Elem == "string":
+		w.WriteString("w.writeNullableString(")
+		w.WriteString(val)
+		w.WriteString(", ")
+		genVersionCond(w, f.NullableVersions, f.Versions)
+		w.WriteString(")\n")
+		return
+	default:
+		genVersionBranch(w, f.NullableVersions, f.Versions, func(nullable bool) {
+			genJSONWriteValue(w, t.Elem, val, nullable)
+		})
+		return
+	}
+	if isNullable(f) {
+		w.WriteString("if m.")
+		w.WriteString(f.Name)
+		w.WriteString(" == nil")
+		if !versionsCover(f.NullableVersions, f.Versions) {
+			w.WriteString(" && ")
+			genVersionCond(w, f.NullableVersions, f.Versions)
+		}
+		w.WriteString(" {\nw.writeNull()\n} else {\n")
+	}
+	w.WriteString("w.beginArray()\nfor i := range m.")
+	w.WriteString(f.Name)
+	w.WriteString(" {\n")
+	genJSONWriteValue(w, t.Elem, baseValue(f, "m."+f.Name+"[i]"), false)
+	w.WriteString("}\nw.endArray()\n")
+	if isNullable(f) {
+		w.WriteString("}\n")
+	}
+}
+
+func genJSONWriteValue(w *codegen.File, t, val string, nullable bool) {
+	switch t {
+	case "bool", "boolean":
+		w.WriteString("w.writeBool(")
+	case "int8", "int16", "int32":
+		w.WriteString("w.writeInt(int64(")
+		w.WriteString(val)
+		w.WriteString("))\n")
+		return
+	case "int64":
+		w.WriteString("w.writeInt(")
+	case "string":
+		w.WriteString("w.writeString(")
+	case "bytes", "records":
+		if nullable {
+			w.WriteString("w.writeNullableBytes(")
+		} else {
+			w.WriteString("w.writeBytes(")
+		}
+	default:
+		if !isStructType(t) {
+			panic("no JSON writer for " + t)
+		}
+		w.WriteString("if err := ")
+		w.WriteString(val)
+		w.WriteString(".marshalJSON(w, v); err != nil {\nreturn err\n}\n")
+		return
+	}
+	w.WriteString(val)
+	w.WriteString(")\n")
+}
+
+func genStructMarshalJSON(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "marshalJSON", "w *jsonWriter, v int16", "error")
+	w.WriteString("w.beginObject()\n")
+
+	for _, f := range fields {
+		genFieldMarshalJSON(w, m, recv, f)
+	}
+
+	w.WriteString("w.endObject()\nreturn nil\n")
+	endMethod(w)
+}
+
+func genStructUnmarshalJSON(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "unmarshalJSON", "o map[string]interface{}, v int16", "error")
+	w.WriteString("m.Reset()\n")
+
+	for _, f := range fields {
+		genFieldUnmarshalJSON(w, m, recv, f)
+	}
+
+	w.WriteString("return nil\n")
+	endMethod(w)
+}
+
+// jsonName is the name Kafka's JSON converters use for f.
+func jsonName(f *schema.Field) string {
+	return strings.ToLower(f.Name[:1]) + f.Name[1:]
+}
@@ -0,0 +1,71 @@
+package kafkaproto
+
+import "sort"
+
+// API describes a request/response pair known to the generated registry.
+type API struct {
+	Key        int16
+	Name       string
+	MinVersion int16
+	MaxVersion int16
+
+	newRequest  func() Message
+	newResponse func() Message
+}
+
+var (
+	apis map[int16]*API
+
+	newRequestHeader  func() Message
+	newResponseHeader func() Message
+)
+
+// APIs returns every registered API, ordered by key.
+func APIs() []*API {
+	a := make([]*API, 0, len(apis))
+	for _, api := range apis {
+		a = append(a, api)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Key < a[j].Key })
+	return a
+}
+
+// LookupAPI returns the API registered for key, or nil if there is none.
+func LookupAPI(key int16) *API {
+	return apis[key]
+}
+
+// NewRequest returns an empty request body, which may come from a pool of
+// released ones.
+func (a *API) NewRequest() Message {
+	return a.newRequest()
+}
+
+// NewResponse returns an empty response body, which may come from a pool of
+// released ones.
+func (a *API) NewResponse() Message {
+	return a.newResponse()
+}
+
+func (a *API) requestHeaderVersion(req Message, v int16) int16 {
+	switch {
+	case a.Key == 7 && v == 0:
+		// ControlledShutdown v0 predates the ClientId header field.
+		return 0
+	case req.isVersionFlexible(v):
+		return 2
+	}
+	return 1
+}
+
+func (a *API) responseHeaderVersion(res Message, v int16) int16 {
+	switch {
+	case a.Key == 18:
+		// ApiVersions responses always use v0 headers, so that clients can
+		// read them before they know which versions the broker supports.
+		return 0
+	case res.isVersionFlexible(v):
+		return 1
+	}
+	return 0
+}
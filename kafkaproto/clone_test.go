@@ -0,0 +1,106 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import "testing"
+
+func TestClone(t *testing.T) {
+	m := testFetchResponse(1, 2, 16)
+	note := "note"
+	m.Responses[0].Partitions[0].AbortedTransactions = []AbortedTransaction{{ProducerId: 1}}
+	c := m.Clone()
+	if !c.Equal(m) {
+		t.Fatalf("clone %+v differs from %+v", c, m)
+	}
+
+	m.Responses[0].Topic = "changed"
+	m.Responses[0].Partitions[0].Records[0] = 1
+	m.Responses[0].Partitions[0].AbortedTransactions[0].ProducerId = 2
+	m.Responses[0].Partitions = m.Responses[0].Partitions[:1]
+	want := testFetchResponse(1, 2, 16)
+	want.Responses[0].Partitions[0].AbortedTransactions = []AbortedTransaction{{ProducerId: 1}}
+	if !c.Equal(want) {
+		t.Errorf("changing the source changed the clone to %+v", c)
+	}
+
+	req := new(DescribeThingsRequest)
+	req.Reset()
+	req.Note = &note
+	cr := req.Clone()
+	note = "changed"
+	if *cr.Note != "note" {
+		t.Errorf("clone shares the note")
+	}
+	if (*DescribeThingsRequest)(nil).Clone() != nil {
+		t.Error("cloned nil")
+	}
+}
+
+func TestEqualVersion(t *testing.T) {
+	base := func() *DescribeThingsRequest {
+		m := new(DescribeThingsRequest)
+		m.Reset()
+		m.GroupId = "g"
+		m.Things = []ThingRef{{Name: "a", ProducerId: 1}}
+		return m
+	}
+	note := "note"
+	cases := []struct {
+		name   string
+		change func(m *DescribeThingsRequest)
+		from   int16 // the first version at which the change is seen
+	}{
+		{"group", func(m *DescribeThingsRequest) { m.GroupId = "h" }, 0},
+		{"producer", func(m *DescribeThingsRequest) { m.Things[0].ProducerId = 2 }, 0},
+		{"flags", func(m *DescribeThingsRequest) { m.Things[0].Flags = []int8{1} }, 1},
+		{"note", func(m *DescribeThingsRequest) { m.Note = &note }, 1},
+		{"weight", func(m *DescribeThingsRequest) { m.Weight = 5 }, 2},
+		{"null things", func(m *DescribeThingsRequest) { m.Things = nil }, 0},
+	}
+	for _, tc := range cases {
+		a, b := base(), base()
+		tc.change(b)
+		if a.Equal(b) {
+			t.Errorf("%s: Equal ignored the change", tc.name)
+		}
+		for v := int16(0); v <= 2; v++ {
+			if got := a.EqualVersion(b, v); got != (v < tc.from) {
+				t.Errorf("%s: EqualVersion at v%d = %v", tc.name, v, got)
+			}
+		}
+	}
+
+	// Null and empty arrays differ only where the array is nullable.
+	a, b := base(), base()
+	a.Things, b.Things = nil, []ThingRef{}
+	if !a.EqualVersion(b, 0) || a.EqualVersion(b, 1) {
+		t.Error("null things compared wrongly")
+	}
+}
+
+// TestCloneOutlivesFrame overwrites the frame a message was decoded from,
+// which under -zerocopy changes the strings of the decoded message but must
+// not change its clone.
+func TestCloneOutlivesFrame(t *testing.T) {
+	for _, v := range []int16{4, 12} {
+		b := appendMessage(nil, testFetchResponse(2, 2, 16), v)
+		want, m := new(FetchResponse), new(FetchResponse)
+		for _, x := range []struct {
+			m *FetchResponse
+			b []byte
+		}{{want, append([]byte(nil), b...)}, {m, b}} {
+			d := decoder{b: x.b, end: len(x.b)}
+			if err := decodeMessage(x.m, &d, v, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c := m.Clone()
+		for i := range b {
+			b[i] = 'x'
+		}
+		if !c.Equal(want) {
+			t.Errorf("v%d: overwriting the frame changed the clone to %+v", v, c)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package kafkaproto
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDialTimeout     = 10 * time.Second
+	defaultRefreshInterval = 5 * time.Minute
+)
+
+type Broker struct {
+	ID   BrokerID
+	Host string
+	Port int32
+	Rack *string
+}
+
+func (b *Broker) Addr() string {
+	return net.JoinHostPort(b.Host, strconv.Itoa(int(b.Port)))
+}
+
+type Partition struct {
+	Topic       TopicName
+	Index       int32
+	ErrorCode   int16
+	Leader      BrokerID
+	LeaderEpoch int32
+	Replicas    []BrokerID
+	ISR         []BrokerID
+	Offline     []BrokerID
+}
+
+type Topic struct {
+	Name       TopicName
+	ErrorCode  int16
+	Internal   bool
+	Partitions map[int32]*Partition
+}
+
+// Cluster is a snapshot of cluster metadata. It must not be modified.
+type Cluster struct {
+	ControllerID BrokerID
+	Brokers      map[BrokerID]*Broker
+	Topics       map[TopicName]*Topic
+	Fetched      time.Time
+}
+
+// metadataRequest is implemented by the generated MetadataRequest.
+type metadataRequest interface {
+	Message
+	setTopics(topics []TopicName)
+}
+
+// metadataResponse is implemented by the generated MetadataResponse.
+type metadataResponse interface {
+	Message
+	cluster() *Cluster
+}
+
+// Client caches cluster metadata and keeps one connection per broker, so
+// that higher level clients can share routing. The metadata is refreshed
+// every RefreshInterval, and on demand after ObserveError sees an error
+// that means it is out of date.
+type Client struct {
+	// Bootstrap lists the addresses used to fetch the first metadata.
+	Bootstrap []string
+
+	ClientID string
+
+	// Topics restricts the metadata to these topics. If nil, all topics are
+	// fetched.
+	Topics []TopicName
+
+	RefreshInterval time.Duration
+	DialTimeout     time.Duration
+
+	// Dial, if set, is used to open the underlying network connections. It
+	// defaults to a net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSConfig, if set, enables TLS. Unless it sets ServerName, the broker
+	// host name is used for SNI and certificate verification. Client
+	// certificates for mutual TLS are taken from it as usual.
+	TLSConfig *tls.Config
+
+	// VectoredWrites, if positive, makes connections write requests with
+	// vectored writes. See Conn.SetVectoredWrites.
+	VectoredWrites int
+
+	// DecodeOptions, if set, bounds the frames and responses read from
+	// brokers.
+	DecodeOptions *DecodeOptions
+
+	// Authenticate, if set, is called on every new connection once TLS and
+	// version negotiation are done, for example to perform a SASL exchange.
+	Authenticate func(ctx context.Context, c *Conn) error
+
+	once sync.Once
+	stop chan struct{}
+
+	mu         sync.Mutex
+	cluster    *Cluster
+	stale      bool
+	conns      map[BrokerID]*Conn
+	refreshing *refresh
+}
+
+type refresh struct {
+	done chan struct{}
+	err  error
+}
+
+func (c *Client) Close() error {
+	c.init()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.stop:
+		return errClosed
+	default:
+		close(c.stop)
+	}
+	for id, cn := range c.conns {
+		cn.Close()
+		delete(c.conns, id)
+	}
+	return nil
+}
+
+// Cluster returns the cached metadata, fetching it first if there is none or
+// it is stale.
+func (c *Client) Cluster(ctx context.Context) (*Cluster, error) {
+	c.init()
+
+	c.mu.Lock()
+	cl, stale := c.cluster, c.stale
+	c.mu.Unlock()
+
+	if cl != nil && !stale {
+		return cl, nil
+	}
+	if err := c.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cluster, nil
+}
+
+// Conn returns the pooled connection to a broker, dialing it if needed.
+func (c *Client) Conn(ctx context.Context, id BrokerID) (*Conn, error) {
+	cl, err := c.Cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := cl.Brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("broker %d: %w", id, errNoBrokers)
+	}
+	return c.brokerConn(ctx, b)
+}
+
+// Leader returns the leader of a partition, refreshing the metadata once if
+// the partition or its leader is unknown.
+func (c *Client) Leader(ctx context.Context, topic TopicName, partition int32) (*Broker, error) {
+	for refreshed := false; ; refreshed = true {
+		cl, err := c.Cluster(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b, err := cl.leader(topic, partition)
+		if err == nil || refreshed {
+			return b, err
+		}
+		c.invalidate()
+	}
+}
+
+// LeaderConn returns the pooled connection to the leader of a partition.
+func (c *Client) LeaderConn(ctx context.Context, topic TopicName, partition int32) (*Conn, error) {
+	b, err := c.Leader(ctx, topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	return c.Conn(ctx, b.ID)
+}
+
+// ObserveError marks the metadata stale if code means that it is out of date.
+// It reports whether it did.
+func (c *Client) ObserveError(code int16) bool {
+	switch code {
+	case errCodeUnknownTopicOrPartition, errCodeLeaderNotAvailable, errCodeNotLeaderOrFollower:
+		c.invalidate()
+		return true
+	}
+	return false
+}
+
+// Refresh fetches new metadata. Concurrent calls share a single fetch.
+func (c *Client) Refresh(ctx context.Context) error {
+	c.init()
+
+	c.mu.Lock()
+	r := c.refreshing
+	if r == nil {
+		r = &refresh{done: make(chan struct{})}
+		c.refreshing = r
+		go c.refresh(r)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) brokerConn(ctx context.Context, b *Broker) (*Conn, error) {
+	c.mu.Lock()
+	cn := c.conns[b.ID]
+	c.mu.Unlock()
+
+	if cn != nil && cn.Err() == nil {
+		return cn, nil
+	}
+	cn, err := c.dial(ctx, b.Addr())
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old := c.conns[b.ID]; old != nil && old.Err() == nil {
+		cn.Close()
+		return old, nil
+	}
+	c.conns[b.ID] = cn
+	return cn, nil
+}
+
+func (c *Client) dial(ctx context.Context, addr string) (*Conn, error) {
+	timeout := c.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := c.Dial
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	nc, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.TLSConfig != nil {
+		if nc, err = handshakeTLS(ctx, nc, addr, c.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+	cn := c.DecodeOptions.NewConn(nc, c.ClientID)
+	cn.SetVectoredWrites(c.VectoredWrites)
+	if err = cn.negotiate(ctx); err == nil && c.Authenticate != nil {
+		err = c.Authenticate(ctx, cn)
+	}
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+func handshakeTLS(ctx context.Context, nc net.Conn, addr string, config *tls.Config) (net.Conn, error) {
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	if d, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(d)
+		defer nc.SetDeadline(time.Time{})
+	}
+	tc := tls.Client(nc, config)
+	if err := tc.Handshake(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (c *Client) fetch(ctx context.Context) (*Cluster, error) {
+	c.mu.Lock()
+	var brokers []*Broker
+	if c.cluster != nil {
+		for _, b := range c.cluster.Brokers {
+			brokers = append(brokers, b)
+		}
+	}
+	c.mu.Unlock()
+
+	err := errNoBrokers
+	for _, b := range brokers {
+		cn, cerr := c.brokerConn(ctx, b)
+		if cerr != nil {
+			err = cerr
+			continue
+		}
+		cl, ferr := c.fetchFrom(ctx, cn)
+		if ferr == nil {
+			return cl, nil
+		}
+		err = ferr
+	}
+	for _, addr := range c.Bootstrap {
+		cn, cerr := c.dial(ctx, addr)
+		if cerr != nil {
+			err = cerr
+			continue
+		}
+		cl, ferr := c.fetchFrom(ctx, cn)
+		cn.Close()
+		if ferr == nil {
+			return cl, nil
+		}
+		err = ferr
+	}
+	return nil, err
+}
+
+func (c *Client) fetchFrom(ctx context.Context, cn *Conn) (*Cluster, error) {
+	v, ok := cn.Version(3)
+	if !ok {
+		return nil, errVersion
+	}
+	req := LookupAPI(3).NewRequest().(metadataRequest)
+	req.setTopics(c.Topics)
+	res, err := cn.RoundTrip(ctx, req, v)
+	req.Release()
+	if err != nil {
+		return nil, err
+	}
+	cl := res.(metadataResponse).cluster()
+	res.Release()
+	cl.Fetched = time.Now()
+	return cl, nil
+}
+
+func (c *Client) init() {
+	c.once.Do(func() {
+		c.stop = make(chan struct{})
+		c.conns = make(map[BrokerID]*Conn)
+		go c.refreshPeriodically()
+	})
+}
+
+func (c *Client) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stale = true
+}
+
+func (c *Client) refresh(r *refresh) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	cl, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		for id, cn := range c.conns {
+			b, ok := cl.Brokers[id]
+			old, known := c.cluster.Brokers[id]
+			if !ok || !known || b.Addr() != old.Addr() {
+				cn.Close()
+				delete(c.conns, id)
+			}
+		}
+		c.cluster, c.stale = cl, false
+	}
+	c.refreshing = nil
+	r.err = err
+	close(r.done)
+}
+
+func (c *Client) refreshPeriodically() {
+	interval := c.RefreshInterval
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			c.Refresh(context.Background())
+		}
+	}
+}
+
+func (cl *Cluster) leader(topic TopicName, partition int32) (*Broker, error) {
+	t, ok := cl.Topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", topic, ErrorCode(errCodeUnknownTopicOrPartition))
+	}
+	if t.ErrorCode != 0 {
+		return nil, fmt.Errorf("%s: %w", topic, ErrorCode(t.ErrorCode))
+	}
+	p, ok := t.Partitions[partition]
+	if !ok {
+		return nil, fmt.Errorf("%s-%d: %w", topic, partition, ErrorCode(errCodeUnknownTopicOrPartition))
+	}
+	if p.ErrorCode != 0 && p.Leader < 0 {
+		return nil, fmt.Errorf("%s-%d: %w", topic, partition, ErrorCode(p.ErrorCode))
+	}
+	b, ok := cl.Brokers[p.Leader]
+	if !ok {
+		return nil, fmt.Errorf("%s-%d: %w", topic, partition, ErrorCode(errCodeLeaderNotAvailable))
+	}
+	return b, nil
+}
@@ -0,0 +1,214 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBrokers serves metadata for brokers 1 and 2 over pipes, with topic "t"
+// led by whichever of them leader names.
+type testBrokers struct {
+	s       *Server
+	leader  int32
+	fetches int32
+	gate    chan struct{}
+
+	mu    sync.Mutex
+	dials map[string][]net.Conn
+}
+
+func newTestBrokers() *testBrokers {
+	tb := &testBrokers{
+		s:      &Server{ErrorLog: log.New(ioutil.Discard, "", 0)},
+		leader: 1,
+		dials:  make(map[string][]net.Conn),
+	}
+	tb.s.HandleMetadata(MetadataHandlerFunc(func(ctx context.Context, r *Request, req *MetadataRequest) (*MetadataResponse, error) {
+		atomic.AddInt32(&tb.fetches, 1)
+		if tb.gate != nil {
+			<-tb.gate
+		}
+		leader := BrokerID(atomic.LoadInt32(&tb.leader))
+		res := new(MetadataResponse)
+		res.Reset()
+		res.Brokers = []MetadataResponseBroker{
+			{NodeId: 1, Host: "b1", Port: 9092},
+			{NodeId: 2, Host: "b2", Port: 9092},
+		}
+		res.Topics = []MetadataResponseTopic{{
+			Name: "t",
+			Partitions: []MetadataResponsePartition{{
+				LeaderId:     leader,
+				ReplicaNodes: []BrokerID{leader, 3 - leader},
+			}},
+		}}
+		return res, nil
+	}))
+	return tb
+}
+
+func (tb *testBrokers) client() *Client {
+	return &Client{
+		Bootstrap: []string{"boot:9092"},
+		ClientID:  "test",
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			tb.mu.Lock()
+			tb.dials[addr] = append(tb.dials[addr], server)
+			tb.mu.Unlock()
+			go tb.s.ServeConn(server)
+			return client, nil
+		},
+	}
+}
+
+func (tb *testBrokers) conns(addr string) []net.Conn {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.dials[addr]
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestClientLeader(t *testing.T) {
+	tb := newTestBrokers()
+	c := tb.client()
+	defer c.Close()
+	ctx := testContext(t)
+
+	b, err := c.Leader(ctx, "t", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ID != 1 || b.Addr() != "b1:9092" {
+		t.Fatalf("leader %+v", b)
+	}
+	cl, err := c.Cluster(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var code ErrorCode
+	if _, err := c.Leader(ctx, "t", 1); !errors.As(err, &code) || code != errCodeUnknownTopicOrPartition {
+		t.Fatalf("unknown partition: %v", err)
+	}
+	if n := atomic.LoadInt32(&tb.fetches); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+
+	// The snapshot must not share arrays with the released responses.
+	atomic.StoreInt32(&tb.leader, 2)
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r := cl.Topics["t"].Partitions[0].Replicas; len(r) != 2 || r[0] != 1 || r[1] != 2 {
+		t.Errorf("old snapshot replicas %v", r)
+	}
+}
+
+func TestClientObserveError(t *testing.T) {
+	tb := newTestBrokers()
+	c := tb.client()
+	defer c.Close()
+	ctx := testContext(t)
+
+	if _, err := c.Leader(ctx, "t", 0); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&tb.leader, 2)
+	if b, err := c.Leader(ctx, "t", 0); err != nil || b.ID != 1 {
+		t.Fatalf("cached leader %v, %v", b, err)
+	}
+	if c.ObserveError(0) {
+		t.Error("no error marked the metadata stale")
+	}
+	if !c.ObserveError(errCodeNotLeaderOrFollower) {
+		t.Fatal("NOT_LEADER_OR_FOLLOWER left the metadata fresh")
+	}
+	if b, err := c.Leader(ctx, "t", 0); err != nil || b.ID != 2 {
+		t.Fatalf("refreshed leader %v, %v", b, err)
+	}
+	if n := atomic.LoadInt32(&tb.fetches); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+}
+
+func TestClientRefreshShared(t *testing.T) {
+	tb := newTestBrokers()
+	c := tb.client()
+	defer c.Close()
+	ctx := testContext(t)
+
+	if _, err := c.Cluster(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tb.gate = make(chan struct{})
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- c.Refresh(ctx)
+		}()
+	}
+	for atomic.LoadInt32(&tb.fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(tb.gate)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&tb.fetches); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+}
+
+func TestClientReplacesBrokenConn(t *testing.T) {
+	tb := newTestBrokers()
+	c := tb.client()
+	defer c.Close()
+	ctx := testContext(t)
+
+	cn, err := c.Conn(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := c.Conn(ctx, 1); err != nil || again != cn {
+		t.Fatalf("connection not pooled: %v", err)
+	}
+	tb.conns("b1:9092")[0].Close()
+	req := new(MetadataRequest)
+	req.Reset()
+	if _, err := cn.RoundTrip(ctx, req, 9); err == nil {
+		t.Fatal("round trip over a closed connection")
+	}
+	next, err := c.Conn(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == cn {
+		t.Fatal("broken connection reused")
+	}
+	if n := len(tb.conns("b1:9092")); n != 2 {
+		t.Errorf("dialed broker 1 %d times, want 2", n)
+	}
+	if _, err := next.RoundTrip(ctx, req, 9); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCollection(t *testing.T) {
+	m := new(ControlledShutdownResponse)
+	m.Reset()
+	m.RemainingPartitions = []RemainingPartition{
+		{TopicName: "a", PartitionIndex: 0},
+		{TopicName: "a", PartitionIndex: 1},
+		{TopicName: "b", PartitionIndex: 0},
+	}
+	if p := m.RemainingPartitions.Find("a", 1); p != &m.RemainingPartitions[1] {
+		t.Errorf("found %v", p)
+	}
+	if p := m.RemainingPartitions.Find("b", 1); p != nil {
+		t.Errorf("found %v", p)
+	}
+	index := m.RemainingPartitions.Index()
+	if len(index) != 3 || index[RemainingPartitionKey{"b", 0}] != &m.RemainingPartitions[2] {
+		t.Errorf("index %v", index)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	m := new(MetadataResponse)
+	m.Reset()
+	m.Brokers = []MetadataResponseBroker{{NodeId: 1, Host: "a"}, {NodeId: 1, Host: "b"}}
+	for _, v := range []int16{0, 9} {
+		frame := encodeResponse(LookupAPI(3), 1, m, v)[4:]
+
+		// Brokers answer every entry, so only strict decoding rejects them.
+		r, err := DecodeResponse(frame, 3, v)
+		if err != nil {
+			t.Fatalf("v%d lenient: %v", v, err)
+		}
+		if b := r.Body.(*MetadataResponse).Brokers; len(b) != 2 || b[1].Host != "b" {
+			t.Errorf("v%d lenient: brokers %+v", v, b)
+		}
+		o := &DecodeOptions{Strict: true}
+		if _, err := o.DecodeResponse(frame, 3, v); !errors.Is(err, errDuplicateKey) {
+			t.Errorf("v%d strict: %v", v, err)
+		}
+	}
+}
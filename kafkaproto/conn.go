@@ -0,0 +1,262 @@
+package kafkaproto
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// Conn is a client connection to a single broker. Requests may be issued
+// concurrently; they are pipelined on the connection and matched to their
+// responses in order.
+type Conn struct {
+	conn     net.Conn
+	clientID *string
+	versions map[int16]API
+	opts     *DecodeOptions
+
+	wmu  sync.Mutex
+	corr int32
+	vec  int
+
+	mu      sync.Mutex
+	pending []*call
+	err     error
+}
+
+type call struct {
+	corr int32
+	api  *API
+	v    int16
+	res  Message
+	err  error
+	done chan struct{}
+}
+
+// apiVersionsResult is implemented by the generated ApiVersionsResponse.
+type apiVersionsResult interface {
+	Message
+	apiVersions() (errorCode int16, apis []API)
+}
+
+// requestHeader is implemented by the generated RequestHeader.
+type requestHeader interface {
+	Message
+	setRequest(key, v int16, corr int32, clientID *string)
+}
+
+// request is implemented by every generated request.
+type request interface {
+	Message
+	request() int16
+}
+
+// NewConn starts a client connection over c, which is closed when the
+// connection fails or is closed.
+func NewConn(c net.Conn, clientID string) *Conn {
+	return newConn(c, clientID, nil)
+}
+
+func newConn(c net.Conn, clientID string, o *DecodeOptions) *Conn {
+	cn := &Conn{conn: c, clientID: &clientID, opts: o}
+	go cn.readResponses()
+	return cn
+}
+
+func (c *Conn) Close() error {
+	c.fail(errClosed)
+	return nil
+}
+
+// SetVectoredWrites makes requests be written with vectored writes, in which
+// bytes and records fields of at least min bytes are referenced instead of
+// being copied into the frame. They pay off on TCP connections, which write
+// them with writev. Zero turns them off. It must be called before the
+// connection is shared.
+func (c *Conn) SetVectoredWrites(min int) {
+	c.vec = min
+}
+
+// ConnectionState returns the negotiated TLS state, reporting false if the
+// connection does not use TLS.
+func (c *Conn) ConnectionState() (tls.ConnectionState, bool) {
+	tc, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tc.ConnectionState(), true
+}
+
+// Err returns the error that closed the connection, if any.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// RoundTrip sends req, encoded at version v, and waits for its response.
+// Requests the broker does not answer, such as a produce with acks=0,
+// return a nil response as soon as they are written.
+func (c *Conn) RoundTrip(ctx context.Context, req Message, v int16) (Message, error) {
+	r, ok := req.(request)
+	if !ok {
+		return nil, errNotRequest
+	}
+	a := LookupAPI(r.request())
+	if a == nil {
+		return nil, errUnknownAPI
+	}
+	if !req.isVersionValid(v) {
+		return nil, errVersion
+	}
+	cl := &call{api: a, v: v, done: make(chan struct{})}
+	expect := ExpectsResponse(req)
+
+	c.wmu.Lock()
+	c.corr++
+	cl.corr = c.corr
+	b := encodeRequest(a, cl.corr, c.clientID, req, v, c.vec)
+
+	c.mu.Lock()
+	err := c.err
+	if err == nil && expect {
+		c.pending = append(c.pending, cl)
+	}
+	c.mu.Unlock()
+
+	if err == nil {
+		_, err = b.WriteTo(c.conn)
+	}
+	c.wmu.Unlock()
+
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	if !expect {
+		return nil, nil
+	}
+
+	select {
+	case <-cl.done:
+		return cl.res, cl.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Version returns the highest version of the api that both the broker and
+// the registry support. It reports false if there is none, or if the
+// connection has not negotiated versions.
+func (c *Conn) Version(key int16) (int16, bool) {
+	a := LookupAPI(key)
+	b, ok := c.versions[key]
+	if a == nil || !ok {
+		return 0, false
+	}
+	min, max := a.MinVersion, a.MaxVersion
+	if b.MinVersion > min {
+		min = b.MinVersion
+	}
+	if b.MaxVersion < max {
+		max = b.MaxVersion
+	}
+	return max, min <= max
+}
+
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	c.conn.Close()
+	for _, cl := range pending {
+		cl.err = err
+		close(cl.done)
+	}
+}
+
+// negotiate asks the broker which versions it supports. It must be called
+// before the connection is shared.
+func (c *Conn) negotiate(ctx context.Context) error {
+	a := LookupAPI(18)
+	req := a.NewRequest()
+	req.Reset()
+	res, err := c.RoundTrip(ctx, req, 0)
+	req.Release()
+	if err != nil {
+		return err
+	}
+	code, apis := res.(apiVersionsResult).apiVersions()
+	res.Release()
+	if code != 0 {
+		return ErrorCode(code)
+	}
+	c.versions = make(map[int16]API, len(apis))
+	for _, api := range apis {
+		c.versions[api.Key] = api
+	}
+	return nil
+}
+
+func (c *Conn) readResponses() {
+	for {
+		b, err := c.opts.ReadFrame(c.conn, nil)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		corr, err := ResponseCorrelationID(b)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		var cl *call
+		if len(c.pending) != 0 && c.pending[0].corr == corr {
+			cl = c.pending[0]
+			c.pending = c.pending[1:]
+		}
+		c.mu.Unlock()
+
+		if cl == nil {
+			c.fail(errCorrelation)
+			return
+		}
+		r, err := c.opts.DecodeResponse(b, cl.api.Key, cl.v)
+		if r != nil {
+			cl.res = r.Body
+			if r.Header != nil {
+				r.Header.Release()
+			}
+		}
+		cl.err = err
+		close(cl.done)
+	}
+}
+
+// encodeRequest returns the complete frame for a request, referencing bytes
+// of at least min bytes if min is positive.
+func encodeRequest(a *API, corr int32, clientID *string, req Message, v int16, min int) net.Buffers {
+	h := newRequestHeader().(requestHeader)
+	h.setRequest(a.Key, v, corr, clientID)
+
+	hv := a.requestHeaderVersion(req, v)
+	var b net.Buffers
+	if min > 0 {
+		b = encodeFrameBuffers(h, hv, req, v, min)
+	} else {
+		b = net.Buffers{encodeFrame(h, hv, req, v)}
+	}
+	h.Release()
+	return b
+}
@@ -0,0 +1,106 @@
+//go:build generated
+// +build generated
+
+package kafkaproto
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnUnansweredRequest(t *testing.T) {
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	produced := make(chan int16, 2)
+	s.HandleProduce(ProduceHandlerFunc(func(ctx context.Context, r *Request, req *ProduceRequest) (*ProduceResponse, error) {
+		produced <- req.Acks
+		res := new(ProduceResponse)
+		res.Reset()
+		return res, nil
+	}))
+	s.HandleMetadata(MetadataHandlerFunc(func(ctx context.Context, r *Request, req *MetadataRequest) (*MetadataResponse, error) {
+		res := new(MetadataResponse)
+		res.Reset()
+		return res, nil
+	}))
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	c := NewConn(client, "test")
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, acks := range []int16{0, 1} {
+		req := new(ProduceRequest)
+		req.Reset()
+		req.Acks = acks
+		res, err := c.RoundTrip(ctx, req, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (res == nil) != (acks == 0) {
+			t.Fatalf("acks=%d: response %v", acks, res)
+		}
+		if got := <-produced; got != acks {
+			t.Fatalf("handled acks=%d, want %d", got, acks)
+		}
+	}
+	req := new(MetadataRequest)
+	req.Reset()
+	if _, err := c.RoundTrip(ctx, req, 9); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnMaxFrameSize(t *testing.T) {
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	s.HandleMetadata(MetadataHandlerFunc(func(ctx context.Context, r *Request, req *MetadataRequest) (*MetadataResponse, error) {
+		res := new(MetadataResponse)
+		res.Reset()
+		id := strings.Repeat("x", 100)
+		res.ClusterId = &id
+		return res, nil
+	}))
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	c := (&DecodeOptions{MaxFrameSize: 64}).NewConn(client, "test")
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req := new(MetadataRequest)
+	req.Reset()
+	if _, err := c.RoundTrip(ctx, req, 9); err != errFrameSize {
+		t.Fatalf("got %v, want %v", err, errFrameSize)
+	}
+}
+
+func TestConnVersion(t *testing.T) {
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	s.HandleMetadata(MetadataHandlerFunc(func(ctx context.Context, r *Request, req *MetadataRequest) (*MetadataResponse, error) {
+		return nil, nil
+	}))
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	c := NewConn(client, "test")
+	defer c.Close()
+
+	if _, ok := c.Version(3); ok {
+		t.Error("version before negotiating")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.negotiate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c.Version(3); !ok || v != LookupAPI(3).MaxVersion {
+		t.Errorf("metadata version %d, %v", v, ok)
+	}
+	if v, ok := c.Version(0); ok {
+		t.Errorf("produce version %d without a handler", v)
+	}
+}
@@ -0,0 +1,126 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertTo(t *testing.T) {
+	metadata := func() *MetadataResponse {
+		m := new(MetadataResponse)
+		m.Reset()
+		m.ThrottleTimeMs = 5
+		m.Topics = []MetadataResponseTopic{{
+			Name:                      "t",
+			TopicAuthorizedOperations: -2147483648,
+			Partitions: []MetadataResponsePartition{{
+				LeaderEpoch:     2,
+				OfflineReplicas: []BrokerID{1},
+			}},
+		}}
+		return m
+	}
+	things := func() *DescribeThingsRequest {
+		m := new(DescribeThingsRequest)
+		m.Reset()
+		m.Things = []ThingRef{{Name: "a"}}
+		return m
+	}
+
+	cases := []struct {
+		name    string
+		m       interface{}
+		v       int16
+		dropped []string
+		err     string
+		check   func(c interface{}) bool
+	}{
+		{
+			name: "same version",
+			m:    metadata(),
+			v:    9,
+		},
+		{
+			name:    "dropped",
+			m:       metadata(),
+			v:       2,
+			dropped: []string{"MetadataResponse.ThrottleTimeMs", "MetadataResponse.Topics[0].Partitions[0].LeaderEpoch", "MetadataResponse.Topics[0].Partitions[0].OfflineReplicas"},
+			check: func(c interface{}) bool {
+				m := c.(*MetadataResponse)
+				p := m.Topics[0].Partitions[0]
+				return m.ThrottleTimeMs == 0 && p.LeaderEpoch == -1 && p.OfflineReplicas == nil
+			},
+		},
+		{
+			name: "nested error",
+			m: func() interface{} {
+				m := metadata()
+				m.Topics[0].TopicAuthorizedOperations = 3
+				return m
+			}(),
+			v:       4,
+			dropped: []string{"MetadataResponse.Topics[0].Partitions[0].LeaderEpoch", "MetadataResponse.Topics[0].Partitions[0].OfflineReplicas"},
+			err:     "MetadataResponse.Topics[0].TopicAuthorizedOperations at version 4: set but not present and not ignorable",
+		},
+		{
+			name: "error",
+			m: func() interface{} {
+				m := things()
+				m.Weight = 3
+				return m
+			}(),
+			v:   1,
+			err: "DescribeThingsRequest.Weight at version 1: set but not present and not ignorable",
+		},
+		{
+			name: "nested collection error",
+			m: func() interface{} {
+				m := things()
+				m.Things = append(m.Things, ThingRef{Name: "b", Flags: []int8{1}})
+				return m
+			}(),
+			v:   0,
+			err: "DescribeThingsRequest.Things[1].Flags at version 0: set but not present and not ignorable",
+		},
+	}
+	for _, tc := range cases {
+		var c interface{}
+		var dropped []*FieldError
+		var err error
+		switch m := tc.m.(type) {
+		case *MetadataResponse:
+			c, dropped, err = m.ConvertTo(tc.v)
+		case *DescribeThingsRequest:
+			c, dropped, err = m.ConvertTo(tc.v)
+		}
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: got error %v, want %s", tc.name, err, tc.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		var paths []string
+		for _, e := range dropped {
+			if !e.Ignorable || e.Version != tc.v {
+				t.Errorf("%s: dropped %+v", tc.name, e)
+			}
+			paths = append(paths, e.Path)
+		}
+		if !reflect.DeepEqual(paths, tc.dropped) {
+			t.Errorf("%s: dropped %q, want %q", tc.name, paths, tc.dropped)
+		}
+		if tc.check != nil && !tc.check(c) {
+			t.Errorf("%s: converted to %v", tc.name, c)
+		}
+	}
+
+	// The source keeps the fields that the copy dropped.
+	m := metadata()
+	if _, _, err := m.ConvertTo(0); err != nil || m.ThrottleTimeMs != 5 {
+		t.Errorf("converting changed the source: %v", err)
+	}
+}
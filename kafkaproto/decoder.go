@@ -1,49 +1,300 @@
 package kafkaproto
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"unsafe"
+)
 
-type decoder []byte
+// decoder decodes values from b, within the limits set by opts, if any. The
+// end of b is at end in the frame, so that errors can say where they are.
+type decoder struct {
+	b     []byte
+	end   int
+	opts  *DecodeOptions
+	depth int
+	tags  []uint64
+}
+
+// offset returns the position in the frame of the next byte to decode.
+func (d *decoder) offset() int {
+	return d.end - len(d.b)
+}
 
 func (d *decoder) decodeBool() bool {
-	b := *d
-	*d = b[1:]
+	b := d.b
+	d.b = b[1:]
 	return b[0] != 0
 }
 
 func (d *decoder) decodeInt8() int8 {
-	b := *d
-	*d = b[1:]
+	b := d.b
+	d.b = b[1:]
 	return int8(b[0])
 }
 
 func (d *decoder) decodeInt16() int16 {
-	b := *d
-	*d = b[2:]
+	b := d.b
+	d.b = b[2:]
 	return int16(binary.BigEndian.Uint16(b[:2]))
 }
 
 func (d *decoder) decodeInt32() int32 {
-	b := *d
-	*d = b[4:]
+	b := d.b
+	d.b = b[4:]
 	return int32(binary.BigEndian.Uint32(b[:4]))
 }
 
 func (d *decoder) decodeInt64() int64 {
-	b := *d
-	*d = b[8:]
+	b := d.b
+	d.b = b[8:]
 	return int64(binary.BigEndian.Uint64(b[:8]))
 }
 
 func (d *decoder) decodeString() string {
-	n := int(d.decodeInt16())
-	b := *d
-	*d = b[n:]
+	n := d.bytesLen(int(d.decodeInt16()))
+	b := d.b
+	d.b = b[n:]
 	return string(b[:n])
 }
 
 func (d *decoder) decodeBytes() []byte {
-	n := int(d.decodeInt32())
-	b := *d
-	*d = b[n:]
+	n := d.bytesLen(int(d.decodeInt32()))
+	b := d.b
+	d.b = b[n:]
 	return b[:n]
 }
+
+func (d *decoder) decodeNullableString() *string {
+	n := d.bytesLen(int(d.decodeInt16()))
+	if n < 0 {
+		return nil
+	}
+	b := d.b
+	d.b = b[n:]
+	s := string(b[:n])
+	return &s
+}
+
+func (d *decoder) decodeNullableBytes() []byte {
+	n := d.bytesLen(int(d.decodeInt32()))
+	if n < 0 {
+		return nil
+	}
+	b := d.b
+	d.b = b[n:]
+	return b[:n]
+}
+
+func (d *decoder) decodeCompactString() string {
+	n := d.bytesLen(d.decodeCompactLen())
+	b := d.b
+	d.b = b[n:]
+	return string(b[:n])
+}
+
+func (d *decoder) decodeCompactBytes() []byte {
+	n := d.bytesLen(d.decodeCompactLen())
+	b := d.b
+	d.b = b[n:]
+	return b[:n]
+}
+
+func (d *decoder) decodeCompactNullableString() *string {
+	n := d.bytesLen(d.decodeCompactLen())
+	if n < 0 {
+		return nil
+	}
+	b := d.b
+	d.b = b[n:]
+	s := string(b[:n])
+	return &s
+}
+
+func (d *decoder) decodeCompactNullableBytes() []byte {
+	n := d.bytesLen(d.decodeCompactLen())
+	if n < 0 {
+		return nil
+	}
+	b := d.b
+	d.b = b[n:]
+	return b[:n]
+}
+
+func (d *decoder) decodeArrayLen() int {
+	return d.arrayLen(int(d.decodeInt32()))
+}
+
+func (d *decoder) decodeCompactArrayLen() int {
+	return d.arrayLen(d.decodeCompactLen())
+}
+
+func (d *decoder) decodeCompactLen() int {
+	return int(d.decodeUvarint()) - 1
+}
+
+func (d *decoder) decodeUvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		panic(errVarint)
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// The skip methods pass over values without decoding them, but check them
+// against the same limits. Null lengths skip nothing.
+
+func (d *decoder) skip(n int) {
+	if n > 0 {
+		d.b = d.b[n:]
+	}
+}
+
+func (d *decoder) skipString() {
+	d.skip(d.bytesLen(int(d.decodeInt16())))
+}
+
+func (d *decoder) skipBytes() {
+	d.skip(d.bytesLen(int(d.decodeInt32())))
+}
+
+func (d *decoder) skipCompact() {
+	d.skip(d.bytesLen(d.decodeCompactLen()))
+}
+
+// decodeTaggedFieldCount begins a section of tagged fields, returning the
+// number of fields in it.
+func (d *decoder) decodeTaggedFieldCount() uint64 {
+	d.tags = d.tags[:0]
+	return d.decodeUvarint()
+}
+
+// decodeTag returns the tag of the next field in a section. Strict decoding
+// fails if it has already been seen in the section.
+func (d *decoder) decodeTag() uint64 {
+	off := d.offset()
+	t := d.decodeUvarint()
+	if !d.opts.strict() {
+		return t
+	}
+	for _, u := range d.tags {
+		if u == t {
+			fail(off, tagError(errDuplicateTag, t))
+		}
+	}
+	d.tags = append(d.tags, t)
+	return t
+}
+
+// decodeTaggedField returns a decoder for the value of the next field in a
+// section.
+func (d *decoder) decodeTaggedField() decoder {
+	n := int(d.decodeUvarint())
+	b := d.b
+	d.b = b[n:]
+	t := *d
+	t.b = b[:n]
+	t.end = d.offset()
+	t.tags = nil
+	return t
+}
+
+// taggedFieldDone is called with the decoder for a known tagged field once
+// its value is decoded. Strict decoding rejects bytes the value left over.
+func (d *decoder) taggedFieldDone() {
+	if d.opts.strict() && len(d.b) != 0 {
+		fail(d.offset(), errTrailing)
+	}
+}
+
+// unknownTag is called with the decoder for a field whose tag t is not in
+// the schema, which strict decoding rejects.
+func (d *decoder) unknownTag(t uint64) {
+	if d.opts.strict() {
+		fail(d.offset(), tagError(errUnknownTag, t))
+	}
+}
+
+func (d *decoder) skipTaggedFields() {
+	for n := d.decodeUvarint(); n > 0; n-- {
+		d.decodeUvarint()
+		d.decodeTaggedField()
+	}
+}
+
+// skipUnknownTaggedFields skips a section of tagged fields in a struct that
+// has none in the schema.
+func (d *decoder) skipUnknownTaggedFields() {
+	for n := d.decodeUvarint(); n > 0; n-- {
+		t := d.decodeUvarint()
+		b := d.decodeTaggedField()
+		b.unknownTag(t)
+	}
+}
+
+// The NoCopy string decoders return strings that alias the frame, which must
+// not be modified or reused while they are in use.
+
+func (d *decoder) decodeStringNoCopy() string {
+	n := d.bytesLen(int(d.decodeInt16()))
+	b := d.b
+	d.b = b[n:]
+	return aliasString(b[:n])
+}
+
+func (d *decoder) decodeNullableStringNoCopy() *string {
+	n := d.bytesLen(int(d.decodeInt16()))
+	if n < 0 {
+		return nil
+	}
+	b := d.b
+	d.b = b[n:]
+	s := aliasString(b[:n])
+	return &s
+}
+
+func (d *decoder) decodeCompactStringNoCopy() string {
+	n := d.bytesLen(d.decodeCompactLen())
+	b := d.b
+	d.b = b[n:]
+	return aliasString(b[:n])
+}
+
+func (d *decoder) decodeCompactNullableStringNoCopy() *string {
+	n := d.bytesLen(d.decodeCompactLen())
+	if n < 0 {
+		return nil
+	}
+	b := d.b
+	d.b = b[n:]
+	s := aliasString(b[:n])
+	return &s
+}
+
+func aliasString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// copyBytes copies bytes fields out of the frame, so that they outlive it. The
+// generator uses it with the -copybytes flag, for fields that the schema does
+// not mark as zero-copy.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+// cloneString copies a string that may alias a frame. The generator uses it
+// in Clone with the -zerocopy flag.
+func cloneString(s string) string {
+	if s == "" {
+		return ""
+	}
+	b := make([]byte, len(s))
+	copy(b, s)
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,54 @@
+package kafkaproto
+
+import "testing"
+
+// benchmarkValues encodes n values with fn, and reports decoding them with
+// each of the decode functions, by name.
+func benchmarkValues(b *testing.B, n int, fn func(e *encoder), decoders map[string]func(d *decoder)) {
+	var e encoder
+	for i := 0; i < n; i++ {
+		fn(&e)
+	}
+	for name, decode := range decoders {
+		decode := decode
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(e.b)))
+			for i := 0; i < b.N; i++ {
+				d := decoder{b: e.b, end: len(e.b)}
+				for j := 0; j < n; j++ {
+					decode(&d)
+				}
+			}
+		})
+	}
+}
+
+var sinkString string
+var sinkBytes []byte
+
+func BenchmarkDecodeString(b *testing.B) {
+	benchmarkValues(b, 1000, func(e *encoder) { e.encodeString("topic-with-a-typical-name") }, map[string]func(d *decoder){
+		"copy":  func(d *decoder) { sinkString = d.decodeString() },
+		"alias": func(d *decoder) { sinkString = d.decodeStringNoCopy() },
+	})
+}
+
+func BenchmarkDecodeBytes(b *testing.B) {
+	records := make([]byte, 1024)
+	benchmarkValues(b, 100, func(e *encoder) { e.encodeBytes(records) }, map[string]func(d *decoder){
+		"alias": func(d *decoder) { sinkBytes = d.decodeBytes() },
+		"copy":  func(d *decoder) { sinkBytes = copyBytes(d.decodeBytes()) },
+	})
+}
+
+func TestDecodeStringNoCopy(t *testing.T) {
+	var e encoder
+	e.encodeString("abc")
+	d := decoder{b: e.b, end: len(e.b)}
+	s := d.decodeStringNoCopy()
+	e.b[2] = 'x'
+	if s != "xbc" {
+		t.Errorf("got %q, want an alias of the frame", s)
+	}
+}
@@ -1,8 +1,15 @@
 package kafkaproto
 
-import "encoding/binary"
+import "net"
 
-type encoder []byte
+// encoder appends encoded values to b. When min is positive, bytes of at
+// least that length are referenced rather than copied: b is moved to bufs
+// before them, and encoding continues in a fresh b.
+type encoder struct {
+	b    []byte
+	bufs net.Buffers
+	min  int
+}
 
 func (e *encoder) encodeBool(v bool) {
 	var b byte
@@ -11,37 +18,125 @@ func (e *encoder) encodeBool(v bool) {
 	} else {
 		b = 0
 	}
-	*e = append(*e, b)
+	e.b = append(e.b, b)
 }
 
 func (e *encoder) encodeInt8(v int8) {
-	*e = append(*e, byte(v))
+	e.b = append(e.b, byte(v))
 }
 
 func (e *encoder) encodeInt16(v int16) {
-	b := make([]byte, 2)
-	binary.BigEndian.PutUint16(b, uint16(v))
-	*e = append(*e, b...)
+	e.b = append(e.b, byte(v>>8), byte(v))
 }
 
 func (e *encoder) encodeInt32(v int32) {
-	b := make([]byte, 4)
-	binary.BigEndian.PutUint32(b, uint32(v))
-	*e = append(*e, b...)
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 }
 
 func (e *encoder) encodeInt64(v int64) {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b, uint64(v))
-	*e = append(*e, b...)
+	e.b = append(e.b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 }
 
 func (e *encoder) encodeString(v string) {
 	e.encodeInt16(int16(len(v)))
-	*e = append(*e, v...)
+	e.b = append(e.b, v...)
 }
 
 func (e *encoder) encodeBytes(v []byte) {
 	e.encodeInt32(int32(len(v)))
-	*e = append(*e, v...)
+	e.write(v)
+}
+
+func (e *encoder) encodeNullableString(v *string) {
+	if v == nil {
+		e.encodeInt16(-1)
+		return
+	}
+	e.encodeString(*v)
+}
+
+func (e *encoder) encodeNullableBytes(v []byte) {
+	if v == nil {
+		e.encodeInt32(-1)
+		return
+	}
+	e.encodeBytes(v)
+}
+
+func (e *encoder) encodeCompactString(v string) {
+	e.encodeCompactArrayLen(len(v))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) encodeCompactBytes(v []byte) {
+	e.encodeCompactArrayLen(len(v))
+	e.write(v)
+}
+
+func (e *encoder) encodeCompactNullableString(v *string) {
+	if v == nil {
+		e.encodeCompactArrayLen(-1)
+		return
+	}
+	e.encodeCompactString(*v)
+}
+
+func (e *encoder) encodeCompactNullableBytes(v []byte) {
+	if v == nil {
+		e.encodeCompactArrayLen(-1)
+		return
+	}
+	e.encodeCompactBytes(v)
+}
+
+func (e *encoder) encodeArrayLen(n int) {
+	e.encodeInt32(int32(n))
+}
+
+func (e *encoder) encodeCompactArrayLen(n int) {
+	e.encodeUvarint(uint64(n + 1))
+}
+
+func (e *encoder) encodeUvarint(v uint64) {
+	for v >= 0x80 {
+		e.b = append(e.b, byte(v)|0x80)
+		v >>= 7
+	}
+	e.b = append(e.b, byte(v))
+}
+
+// write appends b as it is, referencing it if it is long enough.
+func (e *encoder) write(b []byte) {
+	if e.min <= 0 || len(b) < e.min {
+		e.b = append(e.b, b...)
+		return
+	}
+	if len(e.b) != 0 {
+		e.bufs = append(e.bufs, e.b)
+	}
+	e.bufs = append(e.bufs, b)
+
+	// The rest of the capacity of e.b is still free to encode into.
+	e.b = e.b[len(e.b):]
+}
+
+// grow makes room for n more bytes, so that encoding them does not
+// reallocate.
+func (e *encoder) grow(n int) {
+	b := e.b
+	if cap(b)-len(b) < n {
+		nb := make([]byte, len(b), len(b)+n)
+		copy(nb, b)
+		e.b = nb
+	}
+}
+
+// appendMessage appends the encoding of m at version v to dst, growing it
+// only once.
+func appendMessage(dst []byte, m Message, v int16) []byte {
+	e := encoder{b: dst}
+	e.grow(m.size(v))
+	m.encode(&e, v)
+	return e.b
 }
@@ -0,0 +1,88 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncodeFrameBuffers(t *testing.T) {
+	const min = 1024
+
+	produce := testProduceRequest(2, 2, 16)
+	large := produce.Topics[1].Partitions[0].Records
+	large = append(large, make([]byte, min)...)
+	produce.Topics[1].Partitions[0].Records = large
+
+	fetch := testFetchResponse(1, 2, min)
+	fetch.Responses[0].Partitions[1].Records = nil
+
+	cases := []struct {
+		m      Message
+		v      int16
+		header Message
+		hv     int16
+		large  [][]byte
+	}{
+		{produce, 3, newRequestHeader(), 1, [][]byte{large}},
+		{produce, 8, newRequestHeader(), 1, [][]byte{large}},
+		{fetch, 4, newResponseHeader(), 0, [][]byte{fetch.Responses[0].Partitions[0].Records}},
+		{fetch, 12, newResponseHeader(), 1, [][]byte{fetch.Responses[0].Partitions[0].Records}},
+	}
+	for _, tc := range cases {
+		tc.header.Reset()
+		want := encodeFrame(tc.header, tc.hv, tc.m, tc.v)
+		bufs := encodeFrameBuffers(tc.header, tc.hv, tc.m, tc.v, min)
+		if got := bytes.Join(bufs, nil); !bytes.Equal(got, want) {
+			t.Errorf("%T v%d: vectored frame differs", tc.m, tc.v)
+		}
+		var referenced int
+		for _, b := range bufs {
+			for _, l := range tc.large {
+				if len(b) != 0 && &b[0] == &l[0] {
+					referenced++
+				}
+			}
+		}
+		if referenced != len(tc.large) {
+			t.Errorf("%T v%d: %d of %d large fields referenced", tc.m, tc.v, referenced, len(tc.large))
+		}
+		if len(bufs) != 2*len(tc.large)+1 {
+			t.Errorf("%T v%d: %d buffers, want %d", tc.m, tc.v, len(bufs), 2*len(tc.large)+1)
+		}
+	}
+}
+
+func TestConnVectoredWrites(t *testing.T) {
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	got := make(chan *ProduceRequest, 1)
+	s.HandleProduce(ProduceHandlerFunc(func(ctx context.Context, r *Request, req *ProduceRequest) (*ProduceResponse, error) {
+		got <- req.Clone()
+		res := new(ProduceResponse)
+		res.Reset()
+		return res, nil
+	}))
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	c := NewConn(client, "test")
+	c.SetVectoredWrites(64)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req := testProduceRequest(2, 2, 100)
+	req.Topics[0].Partitions[1].Records = []byte("small")
+	if _, err := c.RoundTrip(ctx, req, 8); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-got; !m.EqualVersion(req, 8) {
+		t.Errorf("broker got %+v, want %+v", m, req)
+	}
+}
@@ -0,0 +1,23 @@
+package kafkaproto
+
+// These types are used for fields that the schema marks with an entityType,
+// so that ids of different kinds cannot be mixed up.
+type (
+	BrokerID        int32
+	GroupID         string
+	ProducerID      int64
+	TopicName       string
+	TransactionalID string
+)
+
+// brokerIDs converts broker ids from schemas that predate entity types.
+func brokerIDs(a []int32) []BrokerID {
+	if a == nil {
+		return nil
+	}
+	b := make([]BrokerID, len(a))
+	for i, id := range a {
+		b[i] = BrokerID(id)
+	}
+	return b
+}
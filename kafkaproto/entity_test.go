@@ -0,0 +1,14 @@
+//go:build generated
+// +build generated
+
+package kafkaproto
+
+// The generated fields carry the types of the entities their schemas name.
+var (
+	_ BrokerID         = MetadataResponsePartition{}.LeaderId
+	_ []BrokerID       = MetadataResponsePartition{}.ReplicaNodes
+	_ TopicName        = MetadataResponseTopic{}.Name
+	_ GroupID          = DescribeThingsRequest{}.GroupId
+	_ ProducerID       = ThingRef{}.ProducerId
+	_ *TransactionalID = ProduceRequest{}.TransactionalId
+)
@@ -0,0 +1,14 @@
+package kafkaproto
+
+import "bytes"
+
+func equalBytes(a, b []byte) bool {
+	return bytes.Equal(a, b)
+}
+
+func equalNullableString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
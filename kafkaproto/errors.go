@@ -1,7 +1,68 @@
 package kafkaproto
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
 
 var (
-	errVersion = errors.New("unsupported message version")
+	errArrayLen     = errors.New("array length exceeds limit")
+	errBytesLen     = errors.New("string or bytes length exceeds limit")
+	errClosed       = errors.New("connection closed")
+	errCorrelation  = errors.New("response does not match any pending request")
+	errDepth        = errors.New("nesting depth exceeds limit")
+	errDuplicateKey = errors.New("duplicate map key")
+	errDuplicateTag = errors.New("duplicate tagged field")
+	errFrameSize    = errors.New("invalid frame size")
+	errMalformed    = errors.New("malformed message")
+	errNoBrokers    = errors.New("no brokers available")
+	errNotRequest   = errors.New("message is not a request")
+	errTrailing     = errors.New("trailing bytes after message")
+	errUnknownAPI   = errors.New("unknown api key")
+	errUnknownTag   = errors.New("unknown tagged field")
+	errVarint       = errors.New("malformed varint")
+	errVersion      = errors.New("unsupported message version")
+)
+
+const (
+	errCodeUnknownServerError      = -1
+	errCodeUnknownTopicOrPartition = 3
+	errCodeLeaderNotAvailable      = 5
+	errCodeNotLeaderOrFollower     = 6
+	errCodeUnsupportedVersion      = 35
 )
+
+// ErrorCode is an error code returned by a broker.
+type ErrorCode int16
+
+func (c ErrorCode) Error() string {
+	return "kafka error code " + strconv.Itoa(int(c))
+}
+
+// DecodeError is returned when decoding a message fails. Offset counts from
+// the start of the frame payload, and is where the problem was found: a value
+// that runs past the end of the frame is reported where it starts.
+type DecodeError struct {
+	Message string
+	Version int16
+	Offset  int
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return e.Message + " at version " + strconv.Itoa(int(e.Version)) + ", byte " + strconv.Itoa(e.Offset) + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// fail stops decoding with err, found at offset off.
+func fail(off int, err error) {
+	panic(&DecodeError{Offset: off, Err: err})
+}
+
+func tagError(err error, tag uint64) error {
+	return fmt.Errorf("%w %d", err, tag)
+}
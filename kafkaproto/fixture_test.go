@@ -0,0 +1,49 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import "strconv"
+
+// testFetchResponse returns a response with the given number of topics,
+// partitions per topic and record bytes per partition.
+func testFetchResponse(topics, partitions, records int) *FetchResponse {
+	m := new(FetchResponse)
+	m.Reset()
+	m.Responses = make([]FetchableTopicResponse, topics)
+	for i := range m.Responses {
+		t := &m.Responses[i]
+		t.Reset()
+		t.Topic = TopicName("topic-" + strconv.Itoa(i))
+		t.Partitions = make([]PartitionData, partitions)
+		for j := range t.Partitions {
+			p := &t.Partitions[j]
+			p.Reset()
+			p.PartitionIndex = int32(j)
+			p.HighWatermark = 1000
+			p.AbortedTransactions = []AbortedTransaction{}
+			p.Records = make([]byte, records)
+		}
+	}
+	return m
+}
+
+// testProduceRequest returns a request with the given number of topics,
+// partitions per topic and record bytes per partition.
+func testProduceRequest(topics, partitions, records int) *ProduceRequest {
+	m := new(ProduceRequest)
+	m.Reset()
+	m.Acks = -1
+	m.TimeoutMs = 30000
+	m.Topics = make(TopicProduceDataCollection, topics)
+	for i := range m.Topics {
+		t := &m.Topics[i]
+		t.Reset()
+		t.Name = TopicName("topic-" + strconv.Itoa(i))
+		t.Partitions = make([]PartitionProduceData, partitions)
+		for j := range t.Partitions {
+			t.Partitions[j] = PartitionProduceData{PartitionIndex: int32(j), Records: make([]byte, records)}
+		}
+	}
+	return m
+}
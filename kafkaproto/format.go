@@ -0,0 +1,123 @@
+package kafkaproto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+// maxFormatBytes is how many bytes of a bytes field are printed before the
+// rest is elided.
+const maxFormatBytes = 32
+
+// formatter is implemented by every generated struct.
+type formatter interface {
+	format(p *printer)
+}
+
+// formatMessage implements fmt.Formatter for generated structs. %v and %s
+// print a single line; %+v spreads nested structures over indented lines.
+func formatMessage(f fmt.State, c rune, name string, m formatter) {
+	switch c {
+	case 'v', 's':
+	default:
+		fmt.Fprintf(f, "%%!%c(%s)", c, name)
+		return
+	}
+	p := printer{indent: f.Flag('+')}
+	p.b = append(p.b, name...)
+	m.format(&p)
+	f.Write(p.b)
+}
+
+func formatString(name string, m formatter) string {
+	var p printer
+	p.b = append(p.b, name...)
+	m.format(&p)
+	return string(p.b)
+}
+
+// printer writes the fields of generated structs, Go literal style.
+type printer struct {
+	b      []byte
+	indent bool
+	n      []int
+}
+
+func (p *printer) close(c byte) {
+	n := p.n[len(p.n)-1]
+	p.n = p.n[:len(p.n)-1]
+	if p.indent && n > 0 {
+		p.b = append(p.b, ',')
+		p.newline()
+	}
+	p.b = append(p.b, c)
+}
+
+func (p *printer) elem() {
+	n := &p.n[len(p.n)-1]
+	if *n > 0 {
+		p.b = append(p.b, ',')
+		if !p.indent {
+			p.b = append(p.b, ' ')
+		}
+	}
+	if p.indent {
+		p.newline()
+	}
+	*n++
+}
+
+func (p *printer) field(name string) {
+	p.elem()
+	p.b = append(p.b, name...)
+	p.b = append(p.b, ':', ' ')
+}
+
+func (p *printer) newline() {
+	p.b = append(p.b, '\n')
+	for range p.n {
+		p.b = append(p.b, '\t')
+	}
+}
+
+func (p *printer) open(c byte) {
+	p.b = append(p.b, c)
+	p.n = append(p.n, 0)
+}
+
+func (p *printer) writeBool(v bool) {
+	p.b = strconv.AppendBool(p.b, v)
+}
+
+func (p *printer) writeBytes(v []byte) {
+	if v == nil {
+		p.b = append(p.b, "nil"...)
+		return
+	}
+	p.b = append(p.b, "0x"...)
+	if len(v) <= maxFormatBytes {
+		p.b = append(p.b, hex.EncodeToString(v)...)
+		return
+	}
+	p.b = append(p.b, hex.EncodeToString(v[:maxFormatBytes])...)
+	p.b = append(p.b, "... ("...)
+	p.b = strconv.AppendInt(p.b, int64(len(v)), 10)
+	p.b = append(p.b, " bytes)"...)
+}
+
+func (p *printer) writeInt(v int64) {
+	p.b = strconv.AppendInt(p.b, v, 10)
+}
+
+func (p *printer) writeNullableString(v *string) {
+	if v == nil {
+		p.b = append(p.b, "nil"...)
+		return
+	}
+	p.writeString(*v)
+}
+
+func (p *printer) writeString(v string) {
+	p.b = strconv.AppendQuote(p.b, v)
+}
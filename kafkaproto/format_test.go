@@ -0,0 +1,68 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	empty := new(MetadataRequest)
+	empty.Reset()
+	noCreate := new(MetadataRequest)
+	noCreate.Reset()
+	noCreate.AllowAutoTopicCreation = false
+
+	note := "n"
+	things := new(DescribeThingsRequest)
+	things.Reset()
+	things.GroupId = "g"
+	things.Things = []ThingRef{{Name: "a", ProducerId: 1}, {Name: "b", Flags: []int8{1, -2}}}
+	things.Payload = bytes.Repeat([]byte{0xab}, 40)
+	things.Note = &note
+
+	cases := []struct {
+		format string
+		m      interface{}
+		want   string
+	}{
+		{"%v", empty, `MetadataRequest{}`},
+		{"%v", noCreate, `MetadataRequest{AllowAutoTopicCreation: false}`},
+		{"%v", things, `DescribeThingsRequest{GroupId: "g", Things: [{Name: "a", ProducerId: 1}, {Name: "b", Flags: [1, -2]}], ` +
+			`Payload: 0xabababababababababababababababababababababababababababababababab... (40 bytes), Note: "n"}`},
+		{"%s", &things.Things[0], `ThingRef{Name: "a", ProducerId: 1}`},
+		{"%+v", things, `DescribeThingsRequest{
+	GroupId: "g",
+	Things: [
+		{
+			Name: "a",
+			ProducerId: 1,
+		},
+		{
+			Name: "b",
+			Flags: [
+				1,
+				-2,
+			],
+		},
+	],
+	Payload: 0xabababababababababababababababababababababababababababababababab... (40 bytes),
+	Note: "n",
+}`},
+		{"%+v", empty, `MetadataRequest{}`},
+		{"%d", things, `%!d(DescribeThingsRequest)`},
+		{"%v", testFetchResponse(1, 1, 100), `FetchResponse{Responses: [{Topic: "topic-0", Partitions: [{HighWatermark: 1000, DivergingEpoch: {}, ` +
+			`Records: 0x0000000000000000000000000000000000000000000000000000000000000000... (100 bytes)}]}]}`},
+	}
+	for _, tc := range cases {
+		if got := fmt.Sprintf(tc.format, tc.m); got != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.format, got, tc.want)
+		}
+	}
+	if got, want := things.String(), fmt.Sprint(things); got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package kafkaproto
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// frameChunk is the most that reading a frame allocates ahead of the bytes
+// that have arrived, so that a peer must send a large frame to make room for
+// one.
+const frameChunk = 1 << 20
+
+// ReadFrame reads one size-prefixed frame from r, returning its payload. The
+// payload reuses buf when it has enough capacity.
+func ReadFrame(r io.Reader, buf []byte) ([]byte, error) {
+	return readFrame(r, buf, nil)
+}
+
+func readFrame(r io.Reader, buf []byte, o *DecodeOptions) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := int(int32(binary.BigEndian.Uint32(n[:])))
+	if size < 0 || o != nil && o.MaxFrameSize > 0 && size > o.MaxFrameSize {
+		return nil, errFrameSize
+	}
+	buf, err := appendFrame(r, buf[:0], size)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// appendFrame reads from r into buf until it holds want bytes. Beyond the
+// capacity buf already has, it allocates no more than frameChunk, or the
+// length of buf, ahead of what has been read.
+func appendFrame(r io.Reader, buf []byte, want int) ([]byte, error) {
+	for len(buf) < want {
+		if len(buf) == cap(buf) {
+			c := len(buf) + frameChunk
+			if c < 2*len(buf) {
+				c = 2 * len(buf)
+			}
+			if c > want {
+				c = want
+			}
+			buf = append(make([]byte, 0, c), buf...)
+		}
+		end := cap(buf)
+		if end > want {
+			end = want
+		}
+		n, err := io.ReadFull(r, buf[len(buf):end])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// encodeFrame encodes a header and body into a single size-prefixed frame,
+// sizing it up front so that nothing is copied.
+func encodeFrame(h Message, hv int16, body Message, v int16) []byte {
+	n := h.size(hv) + body.size(v)
+	e := encoder{b: make([]byte, 4, 4+n)}
+	binary.BigEndian.PutUint32(e.b, uint32(n))
+	h.encode(&e, hv)
+	body.encode(&e, v)
+	return e.b
+}
+
+// encodeFrameBuffers is like encodeFrame, but references bytes of at least
+// min bytes instead of copying them into the frame, for a vectored write.
+func encodeFrameBuffers(h Message, hv int16, body Message, v int16, min int) net.Buffers {
+	n := h.size(hv) + body.size(v)
+	e := encoder{b: make([]byte, 4), min: min}
+	binary.BigEndian.PutUint32(e.b, uint32(n))
+	h.encode(&e, hv)
+	body.encode(&e, v)
+	if len(e.b) != 0 {
+		e.bufs = append(e.bufs, e.b)
+	}
+	return e.bufs
+}
+
+// WriteFrame writes b to w, prefixed with its size.
+func WriteFrame(w io.Writer, b []byte) error {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	if _, err := w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,77 @@
+package kafkaproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func sizePrefix(n int) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	return b[:]
+}
+
+func TestReadFrame(t *testing.T) {
+	payload := make([]byte, 3*frameChunk+5)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	b, err := ReadFrame(bytes.NewReader(append(sizePrefix(len(payload)), payload...)), nil)
+	if err != nil || !bytes.Equal(b, payload) {
+		t.Fatalf("read %d bytes: %v", len(b), err)
+	}
+	buf := make([]byte, 0, 16)
+	b, err = ReadFrame(bytes.NewReader(append(sizePrefix(3), 1, 2, 3)), buf)
+	if err != nil || !bytes.Equal(b, []byte{1, 2, 3}) || &b[0] != &buf[:1][0] {
+		t.Fatalf("read %v into the buffer: %v", b, err)
+	}
+}
+
+// TestReadFrameClaimedSize checks that a peer claiming a huge frame cannot
+// make the reader allocate much more than it sends.
+func TestReadFrameClaimedSize(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadFrame(bytes.NewReader(append(sizePrefix(1<<31-1), 1, 2, 3)), nil)
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 4*frameChunk {
+		t.Errorf("allocated %d bytes", n)
+	}
+
+	o := &DecodeOptions{MaxFrameSize: 1024}
+	r := bytes.NewReader(append(sizePrefix(1025), make([]byte, 1025)...))
+	if _, err := o.ReadFrame(r, nil); err != errFrameSize {
+		t.Fatal(err)
+	}
+	if r.Len() != 1025 {
+		t.Errorf("read %d bytes of the frame", 1025-r.Len())
+	}
+}
+
+func TestServerMaxFrameSize(t *testing.T) {
+	s := &Server{
+		ErrorLog:      log.New(ioutil.Discard, "", 0),
+		DecodeOptions: &DecodeOptions{MaxFrameSize: 1024},
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := client.Write(sizePrefix(1 << 30)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection left open: %v", err)
+	}
+}
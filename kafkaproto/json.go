@@ -0,0 +1,278 @@
+package kafkaproto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+var (
+	errJSONArray  = errors.New("expected a JSON array")
+	errJSONBool   = errors.New("expected a JSON boolean")
+	errJSONBytes  = errors.New("expected a base64 JSON string")
+	errJSONInt    = errors.New("expected a JSON integer")
+	errJSONNull   = errors.New("null at a version where it is not nullable")
+	errJSONObject = errors.New("expected a JSON object")
+	errJSONString = errors.New("expected a JSON string")
+)
+
+// MarshalJSON encodes m at version v the way Kafka's generated JSON
+// converters do: only the fields present in v are written, using camelCase
+// names, and bytes are written as base64. It fails if a non-ignorable field
+// that is absent in v holds a non-default value.
+func MarshalJSON(m Message, v int16) ([]byte, error) {
+	if !m.isVersionValid(v) {
+		return nil, errVersion
+	}
+	var w jsonWriter
+	if err := m.marshalJSON(&w, v); err != nil {
+		return nil, err
+	}
+	return w.b, nil
+}
+
+// UnmarshalJSON decodes the output of MarshalJSON, or of Kafka's JSON
+// converters, at version v. Fields that are mandatory in v must be present;
+// unknown fields are ignored.
+func UnmarshalJSON(b []byte, m Message, v int16) error {
+	if !m.isVersionValid(v) {
+		return errVersion
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var x interface{}
+	if err := d.Decode(&x); err != nil {
+		return err
+	}
+	o, err := jsonObject(x)
+	if err != nil {
+		return err
+	}
+	return m.unmarshalJSON(o, v)
+}
+
+// JSONValue returns a value that encoding/json renders as m at version v,
+// the way Kafka's own request logging does. It falls back to m itself if
+// MarshalJSON fails.
+func JSONValue(m Message, v int16) interface{} {
+	if m == nil {
+		return nil
+	}
+	b, err := MarshalJSON(m, v)
+	if err != nil {
+		return m
+	}
+	return json.RawMessage(b)
+}
+
+func jsonFieldError(s, name string, err error) error {
+	return fmt.Errorf("%s: %s: %w", s, name, err)
+}
+
+func jsonMissingError(s, name string, v int16) error {
+	return fmt.Errorf("%s: unable to locate field '%s', which is mandatory in version %d", s, name, v)
+}
+
+func jsonNonDefaultError(s, name string, v int16) error {
+	return fmt.Errorf("%s: attempted to write a non-default %s at version %d", s, name, v)
+}
+
+func jsonArray(x interface{}) ([]interface{}, error) {
+	a, ok := x.([]interface{})
+	if !ok && x != nil {
+		return nil, errJSONArray
+	}
+	return a, nil
+}
+
+func jsonBool(x interface{}) (bool, error) {
+	b, ok := x.(bool)
+	if !ok {
+		return false, errJSONBool
+	}
+	return b, nil
+}
+
+func jsonBytes(x interface{}) ([]byte, error) {
+	if x == nil {
+		return nil, nil
+	}
+	s, ok := x.(string)
+	if !ok {
+		return nil, errJSONBytes
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// jsonInt accepts numbers and, like Kafka, strings holding numbers.
+func jsonInt(x interface{}, bitSize int) (int64, error) {
+	var s string
+	switch x := x.(type) {
+	case json.Number:
+		s = x.String()
+	case string:
+		s = x
+	default:
+		return 0, errJSONInt
+	}
+	return strconv.ParseInt(s, 10, bitSize)
+}
+
+func jsonNullableString(x interface{}) (*string, error) {
+	if x == nil {
+		return nil, nil
+	}
+	s, err := jsonString(x)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func jsonObject(x interface{}) (map[string]interface{}, error) {
+	o, ok := x.(map[string]interface{})
+	if !ok {
+		return nil, errJSONObject
+	}
+	return o, nil
+}
+
+func jsonString(x interface{}) (string, error) {
+	s, ok := x.(string)
+	if !ok {
+		return "", errJSONString
+	}
+	return s, nil
+}
+
+// jsonWriter writes compact JSON formatted like Jackson's, which Kafka uses.
+type jsonWriter struct {
+	b     []byte
+	comma bool
+}
+
+func (w *jsonWriter) beginArray() {
+	w.sep()
+	w.b = append(w.b, '[')
+	w.comma = false
+}
+
+func (w *jsonWriter) beginObject() {
+	w.sep()
+	w.b = append(w.b, '{')
+	w.comma = false
+}
+
+func (w *jsonWriter) endArray() {
+	w.b = append(w.b, ']')
+	w.comma = true
+}
+
+func (w *jsonWriter) endObject() {
+	w.b = append(w.b, '}')
+	w.comma = true
+}
+
+func (w *jsonWriter) name(s string) {
+	w.writeString(s)
+	w.b = append(w.b, ':')
+	w.comma = false
+}
+
+func (w *jsonWriter) sep() {
+	if w.comma {
+		w.b = append(w.b, ',')
+	}
+}
+
+func (w *jsonWriter) writeBool(v bool) {
+	w.sep()
+	w.b = strconv.AppendBool(w.b, v)
+	w.comma = true
+}
+
+func (w *jsonWriter) writeBytes(v []byte) {
+	w.sep()
+	w.b = append(w.b, '"')
+	n := len(w.b)
+	w.b = append(w.b, make([]byte, base64.StdEncoding.EncodedLen(len(v)))...)
+	base64.StdEncoding.Encode(w.b[n:], v)
+	w.b = append(w.b, '"')
+	w.comma = true
+}
+
+func (w *jsonWriter) writeInt(v int64) {
+	w.sep()
+	w.b = strconv.AppendInt(w.b, v, 10)
+	w.comma = true
+}
+
+func (w *jsonWriter) writeNull() {
+	w.sep()
+	w.b = append(w.b, "null"...)
+	w.comma = true
+}
+
+func (w *jsonWriter) writeNullableBytes(v []byte) {
+	if v == nil {
+		w.writeNull()
+		return
+	}
+	w.writeBytes(v)
+}
+
+// writeNullableString writes v, or if it is nil, null when nullable and an
+// empty string otherwise.
+func (w *jsonWriter) writeNullableString(v *string, nullable bool) {
+	switch {
+	case v != nil:
+		w.writeString(*v)
+	case nullable:
+		w.writeNull()
+	default:
+		w.writeString("")
+	}
+}
+
+// writeString escapes only what Jackson escapes: quotes, backslashes and
+// control characters.
+func (w *jsonWriter) writeString(v string) {
+	const hex = "0123456789ABCDEF"
+
+	w.sep()
+	w.b = append(w.b, '"')
+	for i := 0; i < len(v); {
+		c := v[i]
+		if c >= utf8.RuneSelf {
+			r, n := utf8.DecodeRuneInString(v[i:])
+			w.b = append(w.b, string(r)...)
+			i += n
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			w.b = append(w.b, '\\', c)
+		case c == '\n':
+			w.b = append(w.b, '\\', 'n')
+		case c == '\r':
+			w.b = append(w.b, '\\', 'r')
+		case c == '\t':
+			w.b = append(w.b, '\\', 't')
+		case c == '\b':
+			w.b = append(w.b, '\\', 'b')
+		case c == '\f':
+			w.b = append(w.b, '\\', 'f')
+		case c < 0x20:
+			w.b = append(w.b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			w.b = append(w.b, c)
+		}
+		i++
+	}
+	w.b = append(w.b, '"')
+	w.comma = true
+}
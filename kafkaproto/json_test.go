@@ -0,0 +1,130 @@
+//go:build generated
+// +build generated
+
+package kafkaproto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, a := range APIs() {
+		for _, fresh := range []func() Message{a.NewRequest, a.NewResponse} {
+			for v := a.MinVersion; v <= a.MaxVersion; v++ {
+				testJSONRoundTrip(t, fresh, v, false)
+				testJSONRoundTrip(t, fresh, v, true)
+			}
+		}
+	}
+}
+
+// testJSONRoundTrip checks that a message, at its defaults or filled in,
+// survives being written as JSON and read back at version v.
+func testJSONRoundTrip(t *testing.T, fresh func() Message, v int16, fill bool) {
+	m := fresh()
+	m.Reset()
+	if f, ok := m.(interface{ benchFill(int) }); ok && fill {
+		// Pass the message through the wire format so that it holds only
+		// the fields of v.
+		f.benchFill(0)
+		b := appendMessage(nil, m, v)
+		x := fresh()
+		if err := decodeMessage(x, &decoder{b: b, end: len(b)}, v, true); err != nil {
+			t.Fatalf("%T v%d: %v", m, v, err)
+		}
+		m = x
+	}
+	want := appendMessage(nil, m, v)
+	if n := m.size(v); n != len(want) {
+		t.Errorf("%T v%d: size %d, encoded %d bytes", m, v, n, len(want))
+	}
+	b, err := MarshalJSON(m, v)
+	if err != nil {
+		t.Fatalf("%T v%d: %v", m, v, err)
+	}
+	x := fresh()
+	if err := UnmarshalJSON(b, x, v); err != nil {
+		t.Fatalf("%T v%d: %v in %s", m, v, err, b)
+	}
+	if got := appendMessage(nil, x, v); !bytes.Equal(got, want) {
+		t.Errorf("%T v%d: JSON round trip changed the encoding\n%s", m, v, b)
+	}
+	if c, err := MarshalJSON(x, v); err != nil || !bytes.Equal(c, b) {
+		t.Errorf("%T v%d: JSON round trip gave %s, want %s", m, v, c, b)
+	}
+}
+
+func TestJSONNull(t *testing.T) {
+	cases := []struct {
+		key  int16
+		v    int16
+		want string
+	}{
+		{1000, 0, `{"groupId":"","things":[],"payload":""}`},
+		{1000, 1, `{"groupId":"","things":null,"payload":""}`},
+		{1000, 2, `{"groupId":"","things":null,"payload":null}`},
+		{3, 0, `{"topics":[]}`},
+		{3, 1, `{"topics":null}`},
+	}
+	for _, tc := range cases {
+		m := LookupAPI(tc.key).NewRequest()
+		m.Reset()
+		b, err := MarshalJSON(m, tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("%T v%d: got %s, want %s", m, tc.v, b, tc.want)
+		}
+	}
+
+	rejected := []struct {
+		key  int16
+		v    int16
+		json string
+	}{
+		{1000, 0, `{"groupId":"","things":null,"payload":""}`},
+		{1000, 1, `{"groupId":"","things":[],"payload":null}`},
+		{3, 0, `{"topics":null}`},
+	}
+	for _, tc := range rejected {
+		m := LookupAPI(tc.key).NewRequest()
+		if err := UnmarshalJSON([]byte(tc.json), m, tc.v); err == nil {
+			t.Errorf("%T v%d: accepted %s", m, tc.v, tc.json)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	const want = `{"groupId":"g","things":[{"name":"a","producerId":1,"flags":[1,2]}],"payload":"AQID","note":"a \"note\"\n","weight":5}`
+	m := new(DescribeThingsRequest)
+	if err := UnmarshalJSON([]byte(want), m, 2); err != nil {
+		t.Fatal(err)
+	}
+	if m.GroupId != "g" || m.Note == nil || *m.Note != "a \"note\"\n" || m.Weight != 5 || !bytes.Equal(m.Payload, []byte{1, 2, 3}) {
+		t.Errorf("read %+v", m)
+	}
+	if b, err := MarshalJSON(m, 2); err != nil || string(b) != want {
+		t.Fatalf("got %s, %v, want %s", b, err, want)
+	}
+
+	// Fields absent at a version may only hold their defaults.
+	for v, want := range []string{
+		"ThingRef: attempted to write a non-default flags at version 0",
+		"DescribeThingsRequest: attempted to write a non-default weight at version 1",
+	} {
+		if _, err := MarshalJSON(m, int16(v)); err == nil || err.Error() != want {
+			t.Errorf("v%d: got error %v, want %s", v, err, want)
+		}
+	}
+
+	// Mandatory fields are required and unknown fields are ignored.
+	x := new(DescribeThingsRequest)
+	if err := UnmarshalJSON([]byte(`{"things":[],"payload":""}`), x, 0); err == nil {
+		t.Error("accepted a missing groupId")
+	}
+	if err := UnmarshalJSON([]byte(`{"groupId":"g","things":[],"payload":"","extra":[1]}`), x, 0); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,69 @@
+package kafkaproto
+
+// arrayView holds the encoded elements of a lazily decoded array, which are
+// only decoded when they are accessed. The elements alias the frame, which
+// must not be modified or reused while the view is in use.
+type arrayView struct {
+	b    []byte
+	ends []int
+	v    int16
+	d    decoder
+
+	decoded bool
+	null    bool
+}
+
+// begin starts recording an array of n elements at the start of d. The
+// decoder kept for the elements ends where the array starts, so that elem
+// only has to add the end of the element.
+func (a *arrayView) begin(d decoder, v int16, n int) {
+	a.b = d.b
+	a.d = d
+	a.d.b = nil
+	a.d.end = d.offset()
+	a.d.tags = nil
+	a.ends = a.ends[:0]
+	a.v = v
+	a.decoded = true
+	a.null = n < 0
+}
+
+// mark records the end of an element, with d just past it.
+func (a *arrayView) mark(d decoder) {
+	a.ends = append(a.ends, len(a.b)-len(d.b))
+}
+
+func (a *arrayView) end() {
+	n := 0
+	if len(a.ends) != 0 {
+		n = a.ends[len(a.ends)-1]
+	}
+	a.b = a.b[:n:n]
+}
+
+// elem returns a decoder for element i.
+func (a *arrayView) elem(i int) decoder {
+	var start int
+	if i > 0 {
+		start = a.ends[i-1]
+	}
+	d := a.d
+	d.b = a.b[start:a.ends[i]]
+	d.end += a.ends[i]
+	return d
+}
+
+func (a *arrayView) reset() {
+	a.b = nil
+	a.d = decoder{}
+	a.ends = a.ends[:0]
+	a.decoded = false
+	a.null = false
+}
+
+func (a *arrayView) clone() arrayView {
+	c := *a
+	c.b = copyBytes(a.b)
+	c.ends = append([]int(nil), a.ends...)
+	return c
+}
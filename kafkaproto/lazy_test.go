@@ -0,0 +1,124 @@
+//go:build generated && lazy
+// +build generated,lazy
+
+package kafkaproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func testLazyMetadataResponse() *MetadataResponse {
+	m := new(MetadataResponse)
+	m.Reset()
+	brokers := make([]MetadataResponseBroker, 3)
+	topics := make([]MetadataResponseTopic, 3)
+	for i := range brokers {
+		brokers[i] = MetadataResponseBroker{NodeId: BrokerID(i), Host: "broker-" + strconv.Itoa(i), Port: 9092}
+	}
+	for i := range topics {
+		partitions := make([]MetadataResponsePartition, 3)
+		for j := range partitions {
+			p := &partitions[j]
+			p.Reset()
+			p.PartitionIndex = int32(j)
+			p.ReplicaNodes = []BrokerID{0, 1, 2}
+			p.IsrNodes = []BrokerID{0, 1}
+		}
+		t := &topics[i]
+		t.Reset()
+		t.Name = TopicName("topic-" + strconv.Itoa(i))
+		t.Partitions = NewMetadataResponsePartitionView(partitions)
+	}
+	m.Brokers = NewMetadataResponseBrokerView(brokers)
+	m.Topics = NewMetadataResponseTopicView(topics)
+	return m
+}
+
+// TestLazyViews checks that the views of a decoded message hold the
+// elements it was encoded from, and encode back to the same frame.
+func TestLazyViews(t *testing.T) {
+	for _, v := range []int16{1, 9} {
+		frame := encodeResponse(LookupAPI(3), 1, testLazyMetadataResponse(), v)[4:]
+		r, err := DecodeResponse(frame, 3, v)
+		if err != nil {
+			t.Fatalf("v%d: %v", v, err)
+		}
+		m := r.Body.(*MetadataResponse)
+		if n := m.Brokers.Len(); n != 3 {
+			t.Fatalf("v%d: %d brokers", v, n)
+		}
+		if b := m.Brokers.At(1); b.NodeId != 1 || b.Host != "broker-1" {
+			t.Errorf("v%d: broker 1 is %+v", v, b)
+		}
+		if s := m.Brokers.Slice(); len(s) != 3 || s[2].Host != "broker-2" {
+			t.Errorf("v%d: brokers %+v", v, s)
+		}
+		var names []TopicName
+		m.Topics.Range(func(i int, x *MetadataResponseTopic) bool {
+			names = append(names, x.Name)
+			return i < 1
+		})
+		if len(names) != 2 || names[1] != "topic-1" {
+			t.Errorf("v%d: ranged over %v", v, names)
+		}
+		if p := m.Topics.At(2).Partitions.At(1); p.PartitionIndex != 1 || len(p.IsrNodes) != 2 {
+			t.Errorf("v%d: partition %+v", v, p)
+		}
+		if b := encodeResponse(LookupAPI(3), 1, m, v)[4:]; !bytes.Equal(b, frame) {
+			t.Errorf("v%d: encoded %x, want %x", v, b, frame)
+		}
+	}
+}
+
+// walkLazy uses every element of every view in m.
+func walkLazy(m *MetadataResponse, v int16) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	m.Brokers.Slice()
+	m.Topics.Range(func(i int, t *MetadataResponseTopic) bool {
+		t.Partitions.Slice()
+		return true
+	})
+	for i := 0; i < m.Topics.Len(); i++ {
+		m.Topics.At(i).Partitions.Range(func(int, *MetadataResponsePartition) bool {
+			return true
+		})
+	}
+	appendMessage(nil, m, v)
+	_, err = json.Marshal(JSONValue(m, v))
+	return err
+}
+
+// TestLazyAccepted checks that views never fail to decode elements of a
+// message that decoded without error, whatever the options.
+func TestLazyAccepted(t *testing.T) {
+	opts := []*DecodeOptions{nil, {MaxArrayLen: 2, MaxBytesLen: 7}, {Strict: true}}
+	for _, v := range []int16{1, 9} {
+		frame := encodeResponse(LookupAPI(3), 1, testLazyMetadataResponse(), v)[4:]
+		if _, err := DecodeResponse(frame, 3, v); err != nil {
+			t.Fatalf("v%d: %v", v, err)
+		}
+		for i := 4; i < len(frame); i++ {
+			for _, x := range []byte{0, 1, 0x7f, 0x80, 0xff} {
+				b := append([]byte(nil), frame...)
+				b[i] = x
+				for _, o := range opts {
+					r, err := o.DecodeResponse(b, 3, v)
+					if err != nil {
+						continue
+					}
+					if err := walkLazy(r.Body.(*MetadataResponse), v); err != nil {
+						t.Fatalf("v%d: byte %d set to %#x, options %+v: %v", v, i, x, o, err)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package kafkaproto
+
+import (
+	"reflect"
+	"runtime"
+)
+
+// Message is implemented by every generated request, response and header.
+type Message interface {
+	Reset()
+
+	// Release returns the message to a pool for reuse by later decodes. It
+	// must not be used afterwards.
+	Release()
+
+	decode(d *decoder, v int16)
+	encode(e *encoder, v int16)
+	size(v int16) int
+	isVersionFlexible(v int16) bool
+	isVersionValid(v int16) bool
+	marshalJSON(w *jsonWriter, v int16) error
+	unmarshalJSON(o map[string]interface{}, v int16) error
+}
+
+// decodeMessage decodes m from d, returning any error as a *DecodeError. If
+// last is set, strict decoding fails unless m is all that d holds.
+func decodeMessage(m Message, d *decoder, v int16, last bool) error {
+	if err := tryDecode(m, d, v, last); err != nil {
+		return decodeError(m, v, d.offset(), err)
+	}
+	return nil
+}
+
+func tryDecode(m Message, d *decoder, v int16, last bool) (err error) {
+	defer recoverDecode(&err)
+	m.decode(d, v)
+	if last && d.opts.strict() && len(d.b) != 0 {
+		fail(d.offset(), errTrailing)
+	}
+	return nil
+}
+
+// decodeError describes err, which occurred decoding m at off unless it says
+// otherwise.
+func decodeError(m Message, v int16, off int, err error) *DecodeError {
+	e, ok := err.(*DecodeError)
+	if !ok {
+		e = &DecodeError{Offset: off, Err: err}
+	}
+	e.Message = reflect.TypeOf(m).Elem().Name()
+	e.Version = v
+	return e
+}
+
+// recoverDecode turns a panic while decoding into an error.
+func recoverDecode(err *error) {
+	switch r := recover().(type) {
+	case nil:
+	case runtime.Error:
+		*err = errMalformed
+	case error:
+		*err = r
+	default:
+		panic(r)
+	}
+}
@@ -0,0 +1,106 @@
+package kafkaproto
+
+import (
+	"bufio"
+	"io"
+	"net"
+)
+
+// DecodeOptions bounds what decoding untrusted input may allocate, so that a
+// small frame cannot claim a huge array. Zero fields impose no limit. Array
+// lengths are checked against the bytes that remain whatever the options,
+// since every element takes at least one.
+type DecodeOptions struct {
+	// MaxArrayLen limits the number of elements in any one array.
+	MaxArrayLen int
+
+	// MaxBytesLen limits the length of any one string or bytes value.
+	MaxBytesLen int
+
+	// MaxDepth limits the nesting of structs, counting the message itself.
+	MaxDepth int
+
+	// MaxFrameSize limits the size of the frames read with the options, and
+	// is checked before anything is allocated for them.
+	MaxFrameSize int
+
+	// Strict rejects what Kafka itself would skip: bytes left after the body,
+	// tagged fields the schema does not know, tags repeated in a struct, and
+	// keys repeated in an array that is a map.
+	// Proxies should leave it unset, so that newer peers can talk through
+	// them.
+	Strict bool
+}
+
+// DecodeRequest is like the DecodeRequest function, with the options o.
+func (o *DecodeOptions) DecodeRequest(b []byte) (*Request, error) {
+	return decodeRequest(b, o)
+}
+
+// DecodeResponse is like the DecodeResponse function, with the options o.
+func (o *DecodeOptions) DecodeResponse(b []byte, key, v int16) (*Response, error) {
+	return decodeResponse(b, key, v, o)
+}
+
+// StreamResponse is like the StreamResponse function, with the options o.
+func (o *DecodeOptions) StreamResponse(br *bufio.Reader, key, v int16, fn RecordsFunc) (*Response, error) {
+	return streamResponse(br, key, v, fn, o)
+}
+
+// ReadFrame is like the ReadFrame function, with the options o.
+func (o *DecodeOptions) ReadFrame(r io.Reader, buf []byte) ([]byte, error) {
+	return readFrame(r, buf, o)
+}
+
+// NewConn is like the NewConn function, reading and decoding responses with
+// the options o.
+func (o *DecodeOptions) NewConn(c net.Conn, clientID string) *Conn {
+	return newConn(c, clientID, o)
+}
+
+func (o *DecodeOptions) checkArrayLen(n int) {
+	if o != nil && o.MaxArrayLen > 0 && n > o.MaxArrayLen {
+		panic(errArrayLen)
+	}
+}
+
+func (o *DecodeOptions) checkBytesLen(n int) {
+	if o != nil && o.MaxBytesLen > 0 && n > o.MaxBytesLen {
+		panic(errBytesLen)
+	}
+}
+
+func (o *DecodeOptions) checkDepth(n int) {
+	if o != nil && o.MaxDepth > 0 && n > o.MaxDepth {
+		panic(errDepth)
+	}
+}
+
+func (o *DecodeOptions) strict() bool {
+	return o != nil && o.Strict
+}
+
+// arrayLen checks an array length read from the wire.
+func (d *decoder) arrayLen(n int) int {
+	if n > len(d.b) {
+		panic(errMalformed)
+	}
+	d.opts.checkArrayLen(n)
+	return n
+}
+
+// bytesLen checks a string or bytes length read from the wire.
+func (d *decoder) bytesLen(n int) int {
+	d.opts.checkBytesLen(n)
+	return n
+}
+
+// enter and leave bracket the decoding of a struct.
+func (d *decoder) enter() {
+	d.depth++
+	d.opts.checkDepth(d.depth)
+}
+
+func (d *decoder) leave() {
+	d.depth--
+}
@@ -0,0 +1,38 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeOptions(t *testing.T) {
+	frame := encodeResponse(LookupAPI(1), 7, testFetchResponse(2, 3, 100), 12)[4:]
+	cases := []struct {
+		o   *DecodeOptions
+		err error
+	}{
+		{nil, nil},
+		{&DecodeOptions{MaxArrayLen: 3, MaxBytesLen: 100, MaxDepth: 4}, nil},
+		{&DecodeOptions{MaxArrayLen: 2}, errArrayLen},
+		{&DecodeOptions{MaxBytesLen: 99}, errBytesLen},
+		{&DecodeOptions{MaxDepth: 2}, errDepth},
+	}
+	for _, tc := range cases {
+		if _, err := tc.o.DecodeResponse(frame, 1, 12); !errors.Is(err, tc.err) {
+			t.Errorf("%+v: got %v, want %v", tc.o, err, tc.err)
+		}
+	}
+}
+
+// TestDecodeClaimedArrayLen checks that an array claiming more elements than
+// there are bytes left is rejected whatever the options.
+func TestDecodeClaimedArrayLen(t *testing.T) {
+	// A v4 FetchResponse claiming 1<<30 topics.
+	frame := []byte{0, 0, 0, 7, 0, 0, 0, 0, 0x40, 0, 0, 0, 0, 0, 0, 0}
+	if _, err := DecodeResponse(frame, 1, 4); !errors.Is(err, errMalformed) {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import "testing"
+
+func TestDecodeReusesArrays(t *testing.T) {
+	var e encoder
+	testFetchResponse(2, 2, 16).encode(&e, 12)
+	want := new(FetchResponse)
+	d := decoder{b: e.b, end: len(e.b)}
+	if err := decodeMessage(want, &d, 12, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second decode fills in the arrays that the first one allocated.
+	m := AcquireFetchResponse()
+	defer m.Release()
+	var first *FetchableTopicResponse
+	for i := 0; i < 2; i++ {
+		d := decoder{b: e.b, end: len(e.b)}
+		if err := decodeMessage(m, &d, 12, true); err != nil {
+			t.Fatal(err)
+		}
+		if !m.Equal(want) {
+			t.Fatalf("decode %d: %+v", i, m)
+		}
+		if first == nil {
+			first = &m.Responses[0]
+			m.Responses = m.Responses[:1]
+		}
+	}
+	if &m.Responses[0] != first {
+		t.Error("topics not reused")
+	}
+}
+
+func TestClearMatchesReset(t *testing.T) {
+	req := new(MetadataRequest)
+	req.Topics = []MetadataRequestTopic{{Name: "t"}}
+	req.AllowAutoTopicCreation = false
+	req.clear()
+	want := new(MetadataRequest)
+	want.Reset()
+	if req.Topics != nil || !req.Equal(want) {
+		t.Errorf("cleared %+v, reset %+v", req, want)
+	}
+
+	// Only the one array that every version decodes keeps its memory.
+	res := testFetchResponse(1, 2, 16)
+	res.Responses[0].Partitions[0].AbortedTransactions = []AbortedTransaction{{ProducerId: 1}}
+	res.clear()
+	want2 := new(FetchResponse)
+	want2.Reset()
+	if !res.Equal(want2) || cap(res.Responses) == 0 {
+		t.Errorf("cleared %+v, reset %+v", res, want2)
+	}
+}
+
+func TestReleaseClears(t *testing.T) {
+	b := appendMessage(nil, testFetchResponse(2, 2, 16), 12)
+	m := AcquireFetchResponse()
+	d := decoder{b: b, end: len(b)}
+	if err := decodeMessage(m, &d, 12, true); err != nil {
+		t.Fatal(err)
+	}
+	topics := m.Responses
+	m.Release()
+	for i := range topics {
+		if topics[i].Topic != "" || len(topics[i].Partitions) != 0 {
+			t.Fatalf("released topic %+v", topics[i])
+		}
+		for _, p := range topics[i].Partitions[:cap(topics[i].Partitions)] {
+			if p.Records != nil {
+				t.Fatalf("released partition still holds its records")
+			}
+		}
+	}
+}
+
+func TestAcquireAfterDecode(t *testing.T) {
+	req := new(MetadataRequest)
+	req.Reset()
+	req.Topics = []MetadataRequestTopic{{Name: "t"}}
+	b := appendMessage(nil, req, 9)
+	for i := 0; i < 10; i++ {
+		m := AcquireMetadataRequest()
+		if m.Topics != nil || !m.AllowAutoTopicCreation {
+			t.Fatalf("acquired %+v", m)
+		}
+		d := decoder{b: b, end: len(b)}
+		if err := decodeMessage(m, &d, 9, true); err != nil {
+			t.Fatal(err)
+		}
+		m.Release()
+	}
+}
+
+// benchmarkDecode compares decoding into a new message with decoding into one
+// from the pool.
+func benchmarkDecode(b *testing.B, m Message, v int16, fresh, acquire func() Message) {
+	var buf encoder
+	m.encode(&buf, v)
+	decode := func(b *testing.B, m Message) {
+		d := decoder{b: buf.b, end: len(buf.b)}
+		if err := decodeMessage(m, &d, v, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(buf.b)))
+		for i := 0; i < b.N; i++ {
+			decode(b, fresh())
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(buf.b)))
+		for i := 0; i < b.N; i++ {
+			m := acquire()
+			decode(b, m)
+			m.Release()
+		}
+	})
+}
+
+func BenchmarkDecodeFetchResponse(b *testing.B) {
+	benchmarkDecode(b, testFetchResponse(10, 100, 1024), 12,
+		func() Message { return new(FetchResponse) },
+		func() Message { return AcquireFetchResponse() })
+}
+
+func BenchmarkDecodeProduceRequest(b *testing.B) {
+	benchmarkDecode(b, testProduceRequest(10, 100, 1024), 8,
+		func() Message { return new(ProduceRequest) },
+		func() Message { return AcquireProduceRequest() })
+}
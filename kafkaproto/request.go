@@ -0,0 +1,74 @@
+package kafkaproto
+
+import "encoding/binary"
+
+type Request struct {
+	APIKey        int16
+	APIVersion    int16
+	CorrelationID int32
+
+	Header Message
+	Body   Message
+}
+
+// Release returns the header and body to their pools. Neither may be used
+// afterwards.
+func (r *Request) Release() {
+	if r.Header != nil {
+		r.Header.Release()
+	}
+	if r.Body != nil {
+		r.Body.Release()
+	}
+	r.Header, r.Body = nil, nil
+}
+
+// noResponder is implemented by the generated ProduceRequest.
+type noResponder interface {
+	noResponse() bool
+}
+
+// ExpectsResponse reports whether a broker answers the request body m. It
+// does not answer produce requests with acks set to zero.
+func ExpectsResponse(m Message) bool {
+	r, ok := m.(noResponder)
+	return !ok || !r.noResponse()
+}
+
+// DecodeRequest decodes a request frame payload. The returned request is
+// non-nil whenever the frame was long enough to hold the api key, version
+// and correlation id, even if decoding the rest of it failed.
+//
+// Bytes fields of the request alias b, unless the package was generated with
+// -copybytes, and so do strings if it was generated with -zerocopy. Then b
+// must not be modified or reused while the request is in use; Clone makes a
+// copy that does not alias it.
+func DecodeRequest(b []byte) (*Request, error) {
+	return decodeRequest(b, nil)
+}
+
+func decodeRequest(b []byte, o *DecodeOptions) (*Request, error) {
+	if len(b) < 8 {
+		return nil, errMalformed
+	}
+	r := &Request{
+		APIKey:        int16(binary.BigEndian.Uint16(b[0:])),
+		APIVersion:    int16(binary.BigEndian.Uint16(b[2:])),
+		CorrelationID: int32(binary.BigEndian.Uint32(b[4:])),
+	}
+	a := LookupAPI(r.APIKey)
+	if a == nil {
+		return r, errUnknownAPI
+	}
+	body := a.NewRequest()
+	if !body.isVersionValid(r.APIVersion) {
+		return r, errVersion
+	}
+	d := decoder{b: b, end: len(b), opts: o}
+	r.Header = newRequestHeader()
+	if err := decodeMessage(r.Header, &d, a.requestHeaderVersion(body, r.APIVersion), false); err != nil {
+		return r, err
+	}
+	r.Body = body
+	return r, decodeMessage(body, &d, r.APIVersion, true)
+}
@@ -0,0 +1,77 @@
+package kafkaproto
+
+import "encoding/binary"
+
+// responseHeader is implemented by the generated ResponseHeader.
+type responseHeader interface {
+	Message
+	setCorrelationID(id int32)
+}
+
+type Response struct {
+	CorrelationID int32
+
+	Header Message
+	Body   Message
+}
+
+// Release returns the header and body to their pools. Neither may be used
+// afterwards.
+func (r *Response) Release() {
+	if r.Header != nil {
+		r.Header.Release()
+	}
+	if r.Body != nil {
+		r.Body.Release()
+	}
+	r.Header, r.Body = nil, nil
+}
+
+// DecodeResponse decodes a response frame payload for a request with the given
+// api key and version. Like DecodeRequest, it may return fields that alias b.
+func DecodeResponse(b []byte, key, v int16) (*Response, error) {
+	return decodeResponse(b, key, v, nil)
+}
+
+func decodeResponse(b []byte, key, v int16, o *DecodeOptions) (*Response, error) {
+	corr, err := ResponseCorrelationID(b)
+	if err != nil {
+		return nil, err
+	}
+	r := &Response{CorrelationID: corr}
+	a := LookupAPI(key)
+	if a == nil {
+		return r, errUnknownAPI
+	}
+	body := a.NewResponse()
+	if !body.isVersionValid(v) {
+		return r, errVersion
+	}
+	d := decoder{b: b, end: len(b), opts: o}
+	r.Header = newResponseHeader()
+	if err := decodeMessage(r.Header, &d, a.responseHeaderVersion(body, v), false); err != nil {
+		return r, err
+	}
+	r.Body = body
+	return r, decodeMessage(body, &d, v, true)
+}
+
+// encodeResponse returns the complete frame for a response.
+func encodeResponse(a *API, corr int32, res Message, v int16) []byte {
+	h := newResponseHeader().(responseHeader)
+	h.Reset()
+	h.setCorrelationID(corr)
+
+	b := encodeFrame(h, a.responseHeaderVersion(res, v), res, v)
+	h.Release()
+	return b
+}
+
+// ResponseCorrelationID returns the correlation id of a response frame payload,
+// which is needed to find the request it answers before it can be decoded.
+func ResponseCorrelationID(b []byte) (int32, error) {
+	if len(b) < 4 {
+		return 0, errMalformed
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
@@ -0,0 +1,224 @@
+package kafkaproto
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+const defaultMaxInFlight = 16
+
+// Server accepts Kafka client connections and dispatches their requests to
+// the handlers registered with its generated Handle* methods.
+//
+// Requests on a connection are processed concurrently, up to MaxInFlight at a
+// time, but their responses are always written in request order. ApiVersions
+// is answered from the registry, listing only the APIs that have handlers,
+// unless a handler is registered for it; at an unsupported version it gets
+// UNSUPPORTED_VERSION at version 0, which every client can read. Requests for
+// other APIs without a handler, or at a version outside the registered range,
+// close the connection, as a broker would, since there is no version their
+// response could be written at.
+//
+// When a handler fails, the response carries the ErrorCode found in the
+// error, or UNKNOWN_SERVER_ERROR, if the API has a top level error code at
+// the request version. Otherwise the connection is closed.
+type Server struct {
+	MaxInFlight int
+	ErrorLog    *log.Logger
+
+	// DecodeOptions, if set, bounds the frames and requests read from
+	// clients.
+	DecodeOptions *DecodeOptions
+
+	mu       sync.RWMutex
+	handlers map[int16]handlerFunc
+}
+
+type handlerFunc func(ctx context.Context, r *Request) (Message, error)
+
+// errorResponse is implemented by the generated responses with a top level
+// error code. setErrorCode reports false at versions without one.
+type errorResponse interface {
+	Message
+	setErrorCode(code, v int16) bool
+}
+
+// apiVersionsResponse is implemented by the generated ApiVersionsResponse.
+type apiVersionsResponse interface {
+	Message
+	setAPIVersions(errorCode int16, apis []*API)
+}
+
+type result struct {
+	b     []byte
+	close bool
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeTLS is like Serve, but performs a TLS handshake on every connection.
+// Setting ClientAuth in config enables mutual TLS.
+func (s *Server) ServeTLS(l net.Listener, config *tls.Config) error {
+	return s.Serve(tls.NewListener(l, config))
+}
+
+func (s *Server) ServeConn(c net.Conn) {
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := s.MaxInFlight
+	if n <= 0 {
+		n = defaultMaxInFlight
+	}
+	queue := make(chan chan result, n-1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.writeResults(c, queue)
+	}()
+
+	for {
+		b, err := s.DecodeOptions.ReadFrame(c, nil)
+		if err != nil {
+			break
+		}
+		ch := make(chan result, 1)
+		queue <- ch
+		go func() {
+			ch <- s.process(ctx, c, b)
+		}()
+	}
+	close(queue)
+	<-done
+}
+
+func (s *Server) writeResults(c net.Conn, queue <-chan chan result) {
+	var closed bool
+	for ch := range queue {
+		r := <-ch
+		if closed {
+			continue
+		}
+		if r.close {
+			closed = true
+			c.Close()
+			continue
+		}
+		if r.b == nil {
+			continue
+		}
+		if _, err := c.Write(r.b); err != nil {
+			closed = true
+			c.Close()
+		}
+	}
+}
+
+func (s *Server) process(ctx context.Context, c net.Conn, b []byte) result {
+	r, err := s.DecodeOptions.DecodeRequest(b)
+	if r == nil {
+		s.logf("%s: %v", c.RemoteAddr(), err)
+		return result{close: true}
+	}
+	if r.APIKey == 18 && (err == errVersion || err == nil && s.handler(18) == nil) {
+		var code int16
+		if err != nil {
+			code = errCodeUnsupportedVersion
+			r.APIVersion = 0
+		}
+		return result{b: s.encodeAPIVersions(r, code)}
+	}
+	if err != nil {
+		s.logf("%s: api %d v%d: %v", c.RemoteAddr(), r.APIKey, r.APIVersion, err)
+		return result{close: true}
+	}
+
+	h := s.handler(r.APIKey)
+	if h == nil {
+		s.logf("%s: api %d v%d: no handler", c.RemoteAddr(), r.APIKey, r.APIVersion)
+		return result{close: true}
+	}
+	res, err := h(ctx, r)
+	if err != nil {
+		s.logf("%s: api %d v%d: %v", c.RemoteAddr(), r.APIKey, r.APIVersion, err)
+		return s.errorResult(r, err)
+	}
+	if res == nil || !ExpectsResponse(r.Body) {
+		return result{}
+	}
+	return result{b: encodeResponse(LookupAPI(r.APIKey), r.CorrelationID, res, r.APIVersion)}
+}
+
+// errorResult answers a request whose handler failed with the error code of
+// err, or UNKNOWN_SERVER_ERROR, if the response has a top level error code.
+// Otherwise the connection is closed.
+func (s *Server) errorResult(r *Request, err error) result {
+	if !ExpectsResponse(r.Body) {
+		return result{}
+	}
+	code := int16(errCodeUnknownServerError)
+	var ec ErrorCode
+	if errors.As(err, &ec) {
+		code = int16(ec)
+	}
+	a := LookupAPI(r.APIKey)
+	res, ok := a.NewResponse().(errorResponse)
+	if !ok || !res.setErrorCode(code, r.APIVersion) {
+		return result{close: true}
+	}
+	b := encodeResponse(a, r.CorrelationID, res, r.APIVersion)
+	res.Release()
+	return result{b: b}
+}
+
+func (s *Server) encodeAPIVersions(r *Request, code int16) []byte {
+	var supported []*API
+	for _, api := range APIs() {
+		if api.Key == 18 || s.handler(api.Key) != nil {
+			supported = append(supported, api)
+		}
+	}
+	a := LookupAPI(18)
+	res := a.NewResponse().(apiVersionsResponse)
+	res.setAPIVersions(code, supported)
+	return encodeResponse(a, r.CorrelationID, res, r.APIVersion)
+}
+
+func (s *Server) handle(key int16, h handlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.handlers == nil {
+		s.handlers = make(map[int16]handlerFunc)
+	}
+	s.handlers[key] = h
+}
+
+func (s *Server) handler(key int16) handlerFunc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.handlers[key]
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if l := s.ErrorLog; l != nil {
+		l.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,189 @@
+//go:build generated
+// +build generated
+
+package kafkaproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testRequest struct {
+	m Message
+	v int16
+}
+
+// exchange sends reqs over a new connection to s, with correlation ids
+// counting from 1, and returns the responses read until the connection
+// closes or every request has been answered.
+func exchange(t *testing.T, s *Server, reqs ...testRequest) []*Response {
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+
+	go func() {
+		for i, r := range reqs {
+			a := LookupAPI(r.m.(request).request())
+			b := encodeRequest(a, int32(i+1), nil, r.m, r.v, 0)
+			if _, err := b.WriteTo(client); err != nil {
+				return
+			}
+		}
+	}()
+	var res []*Response
+	for range reqs {
+		b, err := ReadFrame(client, nil)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		corr, _ := ResponseCorrelationID(b)
+		r := reqs[corr-1]
+		v := r.v
+		if !r.m.isVersionValid(v) {
+			// Only ApiVersions is answered, at version 0.
+			v = 0
+		}
+		resp, err := DecodeResponse(b, r.m.(request).request(), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, resp)
+	}
+	return res
+}
+
+func shutdownRequest(id BrokerID) *ControlledShutdownRequest {
+	m := new(ControlledShutdownRequest)
+	m.Reset()
+	m.BrokerId = id
+	return m
+}
+
+func TestServerOrder(t *testing.T) {
+	s := &Server{MaxInFlight: 4, ErrorLog: log.New(ioutil.Discard, "", 0)}
+	var running, most int32
+	s.HandleControlledShutdown(ControlledShutdownHandlerFunc(func(ctx context.Context, r *Request, req *ControlledShutdownRequest) (*ControlledShutdownResponse, error) {
+		n := atomic.AddInt32(&running, 1)
+		for m := atomic.LoadInt32(&most); n > m && !atomic.CompareAndSwapInt32(&most, m, n); m = atomic.LoadInt32(&most) {
+		}
+		// Later requests finish first.
+		time.Sleep(time.Duration(10-req.BrokerId) * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		res := new(ControlledShutdownResponse)
+		res.Reset()
+		res.ErrorCode = int16(req.BrokerId)
+		return res, nil
+	}))
+
+	var reqs []testRequest
+	for i := 0; i < 10; i++ {
+		reqs = append(reqs, testRequest{shutdownRequest(BrokerID(i)), int16(i % 4)})
+	}
+	res := exchange(t, s, reqs...)
+	if len(res) != len(reqs) {
+		t.Fatalf("got %d responses", len(res))
+	}
+	for i, r := range res {
+		if r.CorrelationID != int32(i+1) || r.Body.(*ControlledShutdownResponse).ErrorCode != int16(i) {
+			t.Errorf("response %d: %v %v", i, r.CorrelationID, r.Body)
+		}
+	}
+	if most < 2 || most > 4 {
+		t.Errorf("ran %d handlers at once, want 2 to 4", most)
+	}
+}
+
+func TestServerAPIVersions(t *testing.T) {
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	s.HandleControlledShutdown(ControlledShutdownHandlerFunc(func(ctx context.Context, r *Request, req *ControlledShutdownRequest) (*ControlledShutdownResponse, error) {
+		return nil, nil
+	}))
+	a := LookupAPI(18)
+	req := a.NewRequest()
+	req.Reset()
+
+	res := exchange(t, s, testRequest{req, a.MaxVersion}, testRequest{req, a.MaxVersion + 1})
+	if len(res) != 2 {
+		t.Fatalf("got %d responses", len(res))
+	}
+	code, apis := res[0].Body.(apiVersionsResult).apiVersions()
+	if code != 0 || len(apis) != 2 || apis[0].Key != 7 || apis[1].Key != 18 {
+		t.Errorf("advertised %d %+v", code, apis)
+	}
+	if code, _ := res[1].Body.(apiVersionsResult).apiVersions(); code != errCodeUnsupportedVersion {
+		t.Errorf("unsupported version answered with %d", code)
+	}
+
+	// A registered handler takes over.
+	s.HandleApiVersions(ApiVersionsHandlerFunc(func(ctx context.Context, r *Request, req *ApiVersionsRequest) (*ApiVersionsResponse, error) {
+		res := new(ApiVersionsResponse)
+		res.Reset()
+		res.ErrorCode = 42
+		return res, nil
+	}))
+	res = exchange(t, s, testRequest{req, 0})
+	if code, _ := res[0].Body.(apiVersionsResult).apiVersions(); code != 42 {
+		t.Errorf("handler not used: %d", code)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	s.HandleControlledShutdown(ControlledShutdownHandlerFunc(func(ctx context.Context, r *Request, req *ControlledShutdownRequest) (*ControlledShutdownResponse, error) {
+		switch req.BrokerId {
+		case 1:
+			return nil, fmt.Errorf("shutting down: %w", ErrorCode(41))
+		case 2:
+			return nil, errors.New("failed")
+		}
+		res := new(ControlledShutdownResponse)
+		res.Reset()
+		return res, nil
+	}))
+	s.HandleMetadata(MetadataHandlerFunc(func(ctx context.Context, r *Request, req *MetadataRequest) (*MetadataResponse, error) {
+		return nil, errors.New("failed")
+	}))
+	metadata := new(MetadataRequest)
+	metadata.Reset()
+	produce := new(ProduceRequest)
+	produce.Reset()
+
+	// Handler errors are answered where the response has an error code.
+	res := exchange(t, s, testRequest{shutdownRequest(1), 3}, testRequest{shutdownRequest(2), 0}, testRequest{shutdownRequest(0), 1})
+	if len(res) != 3 {
+		t.Fatalf("got %d responses", len(res))
+	}
+	for i, want := range []int16{41, errCodeUnknownServerError, 0} {
+		if code := res[i].Body.(*ControlledShutdownResponse).ErrorCode; code != want {
+			t.Errorf("response %d: error code %d, want %d", i, code, want)
+		}
+	}
+
+	// Everything else closes the connection, after the earlier responses.
+	closing := []struct {
+		name string
+		req  testRequest
+	}{
+		{"handler error", testRequest{metadata, 9}},
+		{"unsupported version", testRequest{shutdownRequest(0), 4}},
+		{"no handler", testRequest{produce, 3}},
+	}
+	for _, tc := range closing {
+		res := exchange(t, s, testRequest{shutdownRequest(0), 0}, tc.req, testRequest{shutdownRequest(0), 0})
+		if len(res) != 1 || res[0].CorrelationID != 1 {
+			t.Errorf("%s: got %d responses", tc.name, len(res))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package kafkaproto
+
+// The size functions return the encoded length of a value, matching the
+// encoder method of the same name.
+
+func sizeUvarint(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func sizeString(v string) int {
+	return 2 + len(v)
+}
+
+func sizeBytes(v []byte) int {
+	return 4 + len(v)
+}
+
+func sizeNullableString(v *string) int {
+	if v == nil {
+		return 2
+	}
+	return sizeString(*v)
+}
+
+func sizeNullableBytes(v []byte) int {
+	if v == nil {
+		return 4
+	}
+	return sizeBytes(v)
+}
+
+func sizeCompactString(v string) int {
+	return sizeUvarint(uint64(len(v)+1)) + len(v)
+}
+
+func sizeCompactBytes(v []byte) int {
+	return sizeUvarint(uint64(len(v)+1)) + len(v)
+}
+
+func sizeCompactNullableString(v *string) int {
+	if v == nil {
+		return 1
+	}
+	return sizeCompactString(*v)
+}
+
+func sizeCompactNullableBytes(v []byte) int {
+	if v == nil {
+		return 1
+	}
+	return sizeCompactBytes(v)
+}
@@ -0,0 +1,41 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSize(t *testing.T) {
+	var msgs []Message
+	for _, a := range APIs() {
+		for _, fresh := range []func() Message{a.NewRequest, a.NewResponse} {
+			m := fresh()
+			m.Reset()
+			msgs = append(msgs, m)
+		}
+	}
+	msgs = append(msgs, testFetchResponse(2, 3, 10), testProduceRequest(2, 3, 10))
+
+	prefix := []byte{1, 2, 3}
+	for _, m := range msgs {
+		for v := int16(0); v < 20; v++ {
+			if !m.isVersionValid(v) {
+				continue
+			}
+			var want encoder
+			m.encode(&want, v)
+			b := m.(interface {
+				AppendTo([]byte, int16) []byte
+			}).AppendTo(prefix[:len(prefix):len(prefix)], v)
+			if !bytes.Equal(b[:len(prefix)], prefix) || !bytes.Equal(b[len(prefix):], want.b) {
+				t.Errorf("%T v%d: AppendTo differs from encode", m, v)
+			}
+			if n := m.size(v); n != len(want.b) {
+				t.Errorf("%T v%d: size %d, encoded %d bytes", m, v, n, len(want.b))
+			}
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package kafkaproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// RecordsFunc is called for each records field of a streamed message. The
+// path holds the structs from the message down to the one with the field,
+// each decoded up to that field. The records are read from r, and whatever fn
+// leaves unread is discarded when it returns. A nil RecordsFunc discards all
+// of them.
+type RecordsFunc func(path []interface{}, r io.Reader) error
+
+// streamer is implemented by the generated messages that can be decoded from
+// a stream.
+type streamer interface {
+	Message
+	decodeStream(d *streamDecoder, v int16)
+}
+
+// streamDecoder reads a frame incrementally. Everything but the records is
+// kept in buf, from which it is decoded; buf is only ever appended to, since
+// decoded values may alias it.
+type streamDecoder struct {
+	r     *bufio.Reader
+	size  int
+	n     int
+	buf   []byte
+	start int
+	depth int
+	path  []interface{}
+	fn    RecordsFunc
+	fnErr error
+	opts  *DecodeOptions
+}
+
+// StreamResponse decodes a response frame read from br for a request with the
+// given api key and version. Instead of reading the whole frame first, it
+// decodes the header and body as it reads them, calling fn with each records
+// field rather than holding it in memory. The records fields of the body are
+// left nil. Whatever happens, br is left at the start of the next frame
+// unless reading from it failed.
+func StreamResponse(br *bufio.Reader, key, v int16, fn RecordsFunc) (*Response, error) {
+	return streamResponse(br, key, v, fn, nil)
+}
+
+func streamResponse(br *bufio.Reader, key, v int16, fn RecordsFunc, o *DecodeOptions) (*Response, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(br, n[:]); err != nil {
+		return nil, err
+	}
+	size := int(int32(binary.BigEndian.Uint32(n[:])))
+	if size < 0 || o != nil && o.MaxFrameSize > 0 && size > o.MaxFrameSize {
+		return nil, errFrameSize
+	}
+	d := &streamDecoder{r: br, size: size, n: size, fn: fn, opts: o}
+	defer d.discard()
+
+	if d.n < 4 {
+		return nil, errMalformed
+	}
+	b, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	r := &Response{CorrelationID: int32(binary.BigEndian.Uint32(b))}
+	a := LookupAPI(key)
+	if a == nil {
+		return r, errUnknownAPI
+	}
+	body := a.NewResponse()
+	if !body.isVersionValid(v) {
+		return r, errVersion
+	}
+	r.Header = newResponseHeader()
+	if err := decodeStream(r.Header, d, a.responseHeaderVersion(body, v), false); err != nil {
+		return r, err
+	}
+	r.Body = body
+	return r, decodeStream(body, d, v, true)
+}
+
+// decodeStream decodes m from d like decodeMessage, reading the rest of the
+// frame into memory if m has no records to stream. Errors returned by fn are
+// passed through as they are.
+func decodeStream(m Message, d *streamDecoder, v int16, last bool) error {
+	err := tryStream(m, d, v, last)
+	if err == nil || err == d.fnErr {
+		return err
+	}
+	return decodeError(m, v, d.offset(), err)
+}
+
+func tryStream(m Message, d *streamDecoder, v int16, last bool) (err error) {
+	defer recoverDecode(&err)
+	if s, ok := m.(streamer); ok {
+		s.decodeStream(d, v)
+	} else {
+		b := decoder{b: d.read(d.n), end: d.offset(), opts: d.opts}
+		m.decode(&b, v)
+		if last && d.opts.strict() && len(b.b) != 0 {
+			fail(b.offset(), errTrailing)
+		}
+	}
+	if last && d.opts.strict() && d.n != 0 {
+		fail(d.offset(), errTrailing)
+	}
+	return nil
+}
+
+// offset returns the position in the frame of the next byte to read.
+func (d *streamDecoder) offset() int {
+	return d.size - d.n
+}
+
+// read appends the next n bytes of the frame to buf, returning them.
+func (d *streamDecoder) read(n int) []byte {
+	if n > d.n {
+		panic(errMalformed)
+	}
+	i := len(d.buf)
+	buf, err := appendFrame(d.r, d.buf, i+n)
+	d.buf = buf
+	if err != nil {
+		panic(err)
+	}
+	d.n -= n
+	return d.buf[i:]
+}
+
+// begin starts recording values to be decoded once they have been read.
+func (d *streamDecoder) begin() {
+	d.start = len(d.buf)
+}
+
+// end returns a decoder for the values read since begin.
+func (d *streamDecoder) end() decoder {
+	return decoder{b: d.buf[d.start:len(d.buf):len(d.buf)], end: d.offset(), opts: d.opts, depth: len(d.path)}
+}
+
+func (d *streamDecoder) decodeInt16() int16 {
+	return int16(binary.BigEndian.Uint16(d.read(2)))
+}
+
+func (d *streamDecoder) decodeInt32() int32 {
+	return int32(binary.BigEndian.Uint32(d.read(4)))
+}
+
+func (d *streamDecoder) decodeArrayLen() int {
+	return d.arrayLen(int(d.decodeInt32()))
+}
+
+func (d *streamDecoder) decodeCompactArrayLen() int {
+	return d.arrayLen(d.decodeCompactLen())
+}
+
+func (d *streamDecoder) decodeCompactLen() int {
+	return int(d.decodeUvarint()) - 1
+}
+
+// arrayLen checks an array length read from the stream.
+func (d *streamDecoder) arrayLen(n int) int {
+	if n > d.n {
+		panic(errMalformed)
+	}
+	d.opts.checkArrayLen(n)
+	return n
+}
+
+func (d *streamDecoder) decodeUvarint() uint64 {
+	var x uint64
+	for s := uint(0); s < 64; s += 7 {
+		b := d.read(1)[0]
+		x |= uint64(b&0x7f) << s
+		if b < 0x80 {
+			return x
+		}
+	}
+	panic(errVarint)
+}
+
+func (d *streamDecoder) skip(n int) {
+	if n > 0 {
+		d.read(n)
+	}
+}
+
+func (d *streamDecoder) skipString() {
+	d.skip(d.bytesLen(int(d.decodeInt16())))
+}
+
+func (d *streamDecoder) skipBytes() {
+	d.skip(d.bytesLen(int(d.decodeInt32())))
+}
+
+func (d *streamDecoder) skipCompact() {
+	d.skip(d.bytesLen(d.decodeCompactLen()))
+}
+
+// bytesLen checks a string or bytes length read from the stream, before it
+// is read into memory.
+func (d *streamDecoder) bytesLen(n int) int {
+	d.opts.checkBytesLen(n)
+	return n
+}
+
+func (d *streamDecoder) skipTaggedFields() {
+	for n := d.decodeUvarint(); n > 0; n-- {
+		d.decodeUvarint()
+		d.skip(int(d.decodeUvarint()))
+	}
+}
+
+// skipUnknownTaggedFields skips a section of tagged fields in a struct that
+// has none in the schema.
+func (d *streamDecoder) skipUnknownTaggedFields() {
+	for n := d.decodeUvarint(); n > 0; n-- {
+		t := d.decodeUvarint()
+		size := int(d.decodeUvarint())
+		if d.opts.strict() {
+			fail(d.offset(), tagError(errUnknownTag, t))
+		}
+		d.skip(size)
+	}
+}
+
+func (d *streamDecoder) push(m interface{}) {
+	d.path = append(d.path, m)
+	d.opts.checkDepth(len(d.path))
+}
+
+func (d *streamDecoder) pop() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+// enter and leave bracket the scanning of a struct below the path.
+func (d *streamDecoder) enter() {
+	d.depth++
+	d.opts.checkDepth(len(d.path) + d.depth)
+}
+
+func (d *streamDecoder) leave() {
+	d.depth--
+}
+
+// records passes the n bytes of records that follow to fn, unless they are
+// null.
+func (d *streamDecoder) records(n int) {
+	if n < 0 {
+		return
+	}
+	if n > d.n {
+		panic(errMalformed)
+	}
+	d.n -= n
+	r := &io.LimitedReader{R: d.r, N: int64(n)}
+	var err error
+	if d.fn != nil {
+		err = d.fn(d.path, r)
+	}
+	d.fnErr = err
+	if _, derr := d.r.Discard(int(r.N)); err == nil {
+		err = derr
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// discard skips the rest of the frame, so that r is left at the next one.
+func (d *streamDecoder) discard() {
+	d.r.Discard(d.n)
+	d.n = 0
+}
@@ -0,0 +1,61 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+func TestStreamResponse(t *testing.T) {
+	m := testFetchResponse(2, 3, 100)
+	frame := encodeResponse(LookupAPI(1), 7, m, 12)
+	var n int64
+	count := func(path []interface{}, r io.Reader) error {
+		if len(path) != 3 {
+			t.Fatalf("records at path %v", path)
+		}
+		if _, ok := path[2].(*PartitionData); !ok {
+			t.Errorf("records in %T", path[2])
+		}
+		c, err := io.Copy(ioutil.Discard, r)
+		n += c
+		return err
+	}
+	br := bufio.NewReader(bytes.NewReader(bytes.Repeat(frame, 2)))
+	for _, fn := range []RecordsFunc{count, nil} {
+		r, err := StreamResponse(br, 1, 12, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.CorrelationID != 7 || len(r.Body.(*FetchResponse).Responses) != 2 {
+			t.Fatalf("response %+v", r)
+		}
+	}
+	if n != 6*100 {
+		t.Errorf("read %d bytes of records", n)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("left %v after the frames", err)
+	}
+}
+
+func TestStreamResponseClaimedSize(t *testing.T) {
+	// A v12 FetchResponse claiming a frame of nearly 1GB and 1<<29 topics.
+	frame := append(sizePrefix(1<<30), 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80, 0x80, 0x80, 0x80, 0x02)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := StreamResponse(bufio.NewReader(bytes.NewReader(frame)), 1, 12, nil)
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Fatal("decoded a truncated frame")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 4*frameChunk {
+		t.Errorf("allocated %d bytes", n)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build generated
+// +build generated
+
+package kafkaproto
+
+import (
+	"errors"
+	"testing"
+)
+
+func splice(b []byte, i, drop int, ins ...byte) []byte {
+	out := append([]byte(nil), b[:i]...)
+	out = append(out, ins...)
+	return append(out, b[i+drop:]...)
+}
+
+func TestStrictTaggedFields(t *testing.T) {
+	a := LookupAPI(1000)
+	req := a.NewRequest()
+	req.Reset()
+	var b []byte
+	for _, x := range encodeRequest(a, 1, nil, req, 2, 0) {
+		b = append(b, x...)
+	}
+	b = b[4:]
+	n := len(b)
+
+	// The last byte counts the top-level tagged fields. Weight has tag 1.
+	weight := []byte{1, 8, 0, 0, 0, 0, 0, 0, 0, 5}
+	cases := []struct {
+		name string
+		b    []byte
+		err  error
+		off  int
+	}{
+		{"known", splice(b, n-1, 1, append([]byte{1}, weight...)...), nil, 0},
+		{"trailing", append(b[:n:n], 1), errTrailing, n},
+		{"trailing in field", splice(b, n-1, 1, 1, 1, 9, 0, 0, 0, 0, 0, 0, 0, 5, 0), errTrailing, n + 10},
+		{"repeated", splice(b, n-1, 1, append(append([]byte{2}, weight...), weight...)...), errDuplicateTag, n + 10},
+		{"unknown", splice(b, n-1, 1, append(append([]byte{2}, weight...), 7, 0)...), errUnknownTag, n + 12},
+	}
+	o := &DecodeOptions{Strict: true}
+	for _, c := range cases {
+		if _, err := DecodeRequest(c.b); err != nil {
+			t.Errorf("%s lenient: %v", c.name, err)
+		}
+		_, err := o.DecodeRequest(c.b)
+		if c.err == nil {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			continue
+		}
+		var de *DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, c.err) || de.Offset != c.off {
+			t.Errorf("%s: got %v, want %v at %d", c.name, err, c.err, c.off)
+		}
+	}
+}
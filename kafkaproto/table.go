@@ -0,0 +1,494 @@
+package kafkaproto
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// The table-driven codec encodes and decodes generated structs by walking a
+// description of their fields, rather than through generated methods for
+// each field. The generator uses it with the -tables flag.
+
+type fieldKind uint8
+
+const (
+	kindBool fieldKind = iota
+	kindInt8
+	kindInt16
+	kindInt32
+	kindInt64
+	kindString
+	kindNullableString
+	kindBytes
+	kindStruct
+)
+
+// versionRange is an inclusive range of versions. An empty range has a max of
+// -1.
+type versionRange struct {
+	min, max int16
+}
+
+func (r versionRange) has(v int16) bool {
+	return v >= r.min && v <= r.max
+}
+
+type structTable struct {
+	flexible versionRange
+	tagged   bool
+	fields   []fieldTable
+}
+
+type fieldTable struct {
+	offset uintptr
+	kind   fieldKind
+
+	versions versionRange
+	nullable versionRange
+	flexible versionRange
+
+	// Tagged fields are only encoded in the tagged field section, in tagged
+	// versions, and only when they are not the default.
+	tagged         bool
+	tag            uint64
+	taggedVersions versionRange
+
+	def       int64
+	defString string
+
+	// copy is set for bytes fields that must not alias the frame, and
+	// noCopy for string fields that may.
+	copy   bool
+	noCopy bool
+
+	// elem describes the struct type, or the struct elements of an array.
+	elem *structTable
+
+	// slice is a nil slice of the type of an array field, and elemSize the
+	// size of its elements. reuse is set if every version decodes the array
+	// and none makes it null, so that clearing may keep its memory.
+	slice    interface{}
+	elemSize uintptr
+	reuse    bool
+
+	// keys is the range of versions in which dup checks the elements of a
+	// keyed array for duplicate keys.
+	keys versionRange
+	dup  func(p unsafe.Pointer) bool
+}
+
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+func fieldPtr(p unsafe.Pointer, off uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(p) + off)
+}
+
+func clearStruct(t *structTable, p unsafe.Pointer) {
+	for i := range t.fields {
+		f := &t.fields[i]
+		q := fieldPtr(p, f.offset)
+		if f.slice != nil {
+			if f.reuse {
+				// Clear the elements too, so that the kept memory does not
+				// hold on to what they reference.
+				s := (*sliceHeader)(q)
+				for j := 0; j < s.len; j++ {
+					clearElem(f, fieldPtr(s.data, uintptr(j)*f.elemSize))
+				}
+				s.len = 0
+			} else {
+				*(*sliceHeader)(q) = sliceHeader{}
+			}
+			continue
+		}
+		switch f.kind {
+		case kindBool:
+			*(*bool)(q) = f.def != 0
+		case kindInt8:
+			*(*int8)(q) = int8(f.def)
+		case kindInt16:
+			*(*int16)(q) = int16(f.def)
+		case kindInt32:
+			*(*int32)(q) = int32(f.def)
+		case kindInt64:
+			*(*int64)(q) = f.def
+		case kindString:
+			*(*string)(q) = f.defString
+		case kindNullableString:
+			*(**string)(q) = nil
+		case kindBytes:
+			*(*[]byte)(q) = nil
+		case kindStruct:
+			clearStruct(f.elem, q)
+		}
+	}
+}
+
+func clearElem(f *fieldTable, p unsafe.Pointer) {
+	switch f.kind {
+	case kindString:
+		*(*string)(p) = ""
+	case kindNullableString:
+		*(**string)(p) = nil
+	case kindBytes:
+		*(*[]byte)(p) = nil
+	case kindStruct:
+		clearStruct(f.elem, p)
+	}
+}
+
+func decodeStruct(d *decoder, t *structTable, p unsafe.Pointer, v int16) {
+	d.enter()
+	clearStruct(t, p)
+
+	for i := range t.fields {
+		if f := &t.fields[i]; !f.tagged {
+			decodeField(d, f, p, v)
+		}
+	}
+	switch {
+	case !t.flexible.has(v):
+	case !t.tagged:
+		d.skipUnknownTaggedFields()
+	default:
+		decodeTaggedFields(d, t, p, v)
+	}
+	d.leave()
+}
+
+func decodeTaggedFields(d *decoder, t *structTable, p unsafe.Pointer, v int16) {
+	for n := d.decodeTaggedFieldCount(); n > 0; n-- {
+		tag := d.decodeTag()
+		b := d.decodeTaggedField()
+		known := false
+		for i := range t.fields {
+			if f := &t.fields[i]; f.tagged && f.tag == tag {
+				decodeField(&b, f, p, v)
+				b.taggedFieldDone()
+				known = true
+			}
+		}
+		if !known {
+			b.unknownTag(tag)
+		}
+	}
+}
+
+func decodeField(d *decoder, f *fieldTable, p unsafe.Pointer, v int16) {
+	if !f.versions.has(v) {
+		return
+	}
+	p = fieldPtr(p, f.offset)
+	compact := f.flexible.has(v)
+	if f.slice == nil {
+		decodeValue(d, f, p, v, compact, f.nullable.has(v))
+		return
+	}
+
+	var n int
+	if compact {
+		n = d.decodeCompactArrayLen()
+	} else {
+		n = d.decodeArrayLen()
+	}
+	typ := reflect.TypeOf(f.slice)
+	if n < 0 {
+		if f.nullable.max < 0 {
+			panic(errMalformed)
+		}
+		reflect.NewAt(typ, p).Elem().Set(reflect.Zero(typ))
+		return
+	}
+	// Reuse the capacity, and the elements, left by an earlier decode.
+	s := (*sliceHeader)(p)
+	if s.cap < n {
+		reflect.NewAt(typ, p).Elem().Set(reflect.MakeSlice(typ, n, n))
+	} else {
+		s.len = n
+	}
+	for i := 0; i < n; i++ {
+		decodeValue(d, f, fieldPtr(s.data, uintptr(i)*f.elemSize), v, compact, false)
+	}
+	if f.dup != nil && d.opts.strict() && f.keys.has(v) && f.dup(p) {
+		panic(errDuplicateKey)
+	}
+}
+
+func decodeValue(d *decoder, f *fieldTable, p unsafe.Pointer, v int16, compact, nullable bool) {
+	switch f.kind {
+	case kindBool:
+		*(*bool)(p) = d.decodeBool()
+	case kindInt8:
+		*(*int8)(p) = d.decodeInt8()
+	case kindInt16:
+		*(*int16)(p) = d.decodeInt16()
+	case kindInt32:
+		*(*int32)(p) = d.decodeInt32()
+	case kindInt64:
+		*(*int64)(p) = d.decodeInt64()
+	case kindString:
+		switch {
+		case compact && f.noCopy:
+			*(*string)(p) = d.decodeCompactStringNoCopy()
+		case compact:
+			*(*string)(p) = d.decodeCompactString()
+		case f.noCopy:
+			*(*string)(p) = d.decodeStringNoCopy()
+		default:
+			*(*string)(p) = d.decodeString()
+		}
+	case kindNullableString:
+		switch {
+		case compact && f.noCopy:
+			*(**string)(p) = d.decodeCompactNullableStringNoCopy()
+		case compact:
+			*(**string)(p) = d.decodeCompactNullableString()
+		case f.noCopy:
+			*(**string)(p) = d.decodeNullableStringNoCopy()
+		default:
+			*(**string)(p) = d.decodeNullableString()
+		}
+	case kindBytes:
+		var b []byte
+		switch {
+		case compact && nullable:
+			b = d.decodeCompactNullableBytes()
+		case compact:
+			b = d.decodeCompactBytes()
+		case nullable:
+			b = d.decodeNullableBytes()
+		default:
+			b = d.decodeBytes()
+		}
+		if f.copy {
+			b = copyBytes(b)
+		}
+		*(*[]byte)(p) = b
+	case kindStruct:
+		decodeStruct(d, f.elem, p, v)
+	}
+}
+
+func encodeStruct(e *encoder, t *structTable, p unsafe.Pointer, v int16) {
+	for i := range t.fields {
+		if f := &t.fields[i]; !f.tagged {
+			encodeField(e, f, p, v)
+		}
+	}
+	if !t.flexible.has(v) {
+		return
+	}
+	if !t.tagged {
+		e.encodeUvarint(0)
+		return
+	}
+	var n uint64
+	for i := range t.fields {
+		if isTaggedFieldSet(&t.fields[i], p, v) {
+			n++
+		}
+	}
+	e.encodeUvarint(n)
+	for i := range t.fields {
+		f := &t.fields[i]
+		if isTaggedFieldSet(f, p, v) {
+			e.encodeUvarint(f.tag)
+			e.encodeUvarint(uint64(sizeField(f, p, v)))
+			encodeField(e, f, p, v)
+		}
+	}
+}
+
+func encodeField(e *encoder, f *fieldTable, p unsafe.Pointer, v int16) {
+	if !f.versions.has(v) {
+		return
+	}
+	p = fieldPtr(p, f.offset)
+	compact := f.flexible.has(v)
+	if f.slice == nil {
+		encodeValue(e, f, p, v, compact, f.nullable.has(v))
+		return
+	}
+
+	s := (*sliceHeader)(p)
+	n := s.len
+	if s.data == nil && f.nullable.has(v) {
+		n = -1
+	}
+	if compact {
+		e.encodeCompactArrayLen(n)
+	} else {
+		e.encodeArrayLen(n)
+	}
+	for i := 0; i < s.len; i++ {
+		encodeValue(e, f, fieldPtr(s.data, uintptr(i)*f.elemSize), v, compact, false)
+	}
+}
+
+func encodeValue(e *encoder, f *fieldTable, p unsafe.Pointer, v int16, compact, nullable bool) {
+	switch f.kind {
+	case kindBool:
+		e.encodeBool(*(*bool)(p))
+	case kindInt8:
+		e.encodeInt8(*(*int8)(p))
+	case kindInt16:
+		e.encodeInt16(*(*int16)(p))
+	case kindInt32:
+		e.encodeInt32(*(*int32)(p))
+	case kindInt64:
+		e.encodeInt64(*(*int64)(p))
+	case kindString:
+		if compact {
+			e.encodeCompactString(*(*string)(p))
+		} else {
+			e.encodeString(*(*string)(p))
+		}
+	case kindNullableString:
+		if compact {
+			e.encodeCompactNullableString(*(**string)(p))
+		} else {
+			e.encodeNullableString(*(**string)(p))
+		}
+	case kindBytes:
+		b := *(*[]byte)(p)
+		switch {
+		case compact && nullable:
+			e.encodeCompactNullableBytes(b)
+		case compact:
+			e.encodeCompactBytes(b)
+		case nullable:
+			e.encodeNullableBytes(b)
+		default:
+			e.encodeBytes(b)
+		}
+	case kindStruct:
+		encodeStruct(e, f.elem, p, v)
+	}
+}
+
+func sizeStruct(t *structTable, p unsafe.Pointer, v int16) int {
+	var s int
+	for i := range t.fields {
+		if f := &t.fields[i]; !f.tagged {
+			s += sizeField(f, p, v)
+		}
+	}
+	if !t.flexible.has(v) {
+		return s
+	}
+	if !t.tagged {
+		return s + 1
+	}
+	var n uint64
+	for i := range t.fields {
+		f := &t.fields[i]
+		if isTaggedFieldSet(f, p, v) {
+			x := sizeField(f, p, v)
+			s += sizeUvarint(f.tag) + sizeUvarint(uint64(x)) + x
+			n++
+		}
+	}
+	return s + sizeUvarint(n)
+}
+
+func sizeField(f *fieldTable, p unsafe.Pointer, v int16) int {
+	if !f.versions.has(v) {
+		return 0
+	}
+	p = fieldPtr(p, f.offset)
+	compact := f.flexible.has(v)
+	if f.slice == nil {
+		return sizeValue(f, p, v, compact, f.nullable.has(v))
+	}
+
+	sh := (*sliceHeader)(p)
+	s := 4
+	if compact {
+		n := sh.len
+		if sh.data == nil && f.nullable.has(v) {
+			n = -1
+		}
+		s = sizeUvarint(uint64(n + 1))
+	}
+	switch f.kind {
+	case kindBool, kindInt8, kindInt16, kindInt32, kindInt64:
+		return s + sh.len*int(f.elemSize)
+	}
+	for i := 0; i < sh.len; i++ {
+		s += sizeValue(f, fieldPtr(sh.data, uintptr(i)*f.elemSize), v, compact, false)
+	}
+	return s
+}
+
+func sizeValue(f *fieldTable, p unsafe.Pointer, v int16, compact, nullable bool) int {
+	switch f.kind {
+	case kindBool, kindInt8:
+		return 1
+	case kindInt16:
+		return 2
+	case kindInt32:
+		return 4
+	case kindInt64:
+		return 8
+	case kindString:
+		if compact {
+			return sizeCompactString(*(*string)(p))
+		}
+		return sizeString(*(*string)(p))
+	case kindNullableString:
+		if compact {
+			return sizeCompactNullableString(*(**string)(p))
+		}
+		return sizeNullableString(*(**string)(p))
+	case kindBytes:
+		b := *(*[]byte)(p)
+		switch {
+		case compact && nullable:
+			return sizeCompactNullableBytes(b)
+		case compact:
+			return sizeCompactBytes(b)
+		case nullable:
+			return sizeNullableBytes(b)
+		default:
+			return sizeBytes(b)
+		}
+	case kindStruct:
+		return sizeStruct(f.elem, p, v)
+	}
+	return 0
+}
+
+// isTaggedFieldSet reports whether f is written to the tagged field section
+// at version v.
+func isTaggedFieldSet(f *fieldTable, p unsafe.Pointer, v int16) bool {
+	if !f.tagged || !f.taggedVersions.has(v) {
+		return false
+	}
+	p = fieldPtr(p, f.offset)
+	if f.slice != nil {
+		return (*sliceHeader)(p).len != 0
+	}
+	switch f.kind {
+	case kindBool:
+		return *(*bool)(p) != (f.def != 0)
+	case kindInt8:
+		return int64(*(*int8)(p)) != f.def
+	case kindInt16:
+		return int64(*(*int16)(p)) != f.def
+	case kindInt32:
+		return int64(*(*int32)(p)) != f.def
+	case kindInt64:
+		return *(*int64)(p) != f.def
+	case kindString:
+		return *(*string)(p) != f.defString
+	case kindNullableString:
+		return *(**string)(p) != nil
+	case kindBytes:
+		return len(*(*[]byte)(p)) != 0
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCA is an in-process certificate authority.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a leaf certificate for name, usable by servers and clients.
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsBroker serves metadata over TLS, naming itself "broker" as the only
+// broker.
+func tlsBroker(t *testing.T, config *tls.Config) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	s.HandleMetadata(MetadataHandlerFunc(func(ctx context.Context, r *Request, req *MetadataRequest) (*MetadataResponse, error) {
+		res := new(MetadataResponse)
+		res.Reset()
+		res.Brokers = []MetadataResponseBroker{{NodeId: 1, Host: "broker", Port: int32(p)}}
+		return res, nil
+	}))
+	go s.ServeTLS(l, config)
+	return l
+}
+
+func TestClientTLS(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+	serverCert, clientCert := ca.issue(t, "broker"), ca.issue(t, "client")
+
+	cases := []struct {
+		name   string
+		server *tls.Config
+		client *tls.Config
+		ok     bool
+	}{
+		{
+			name:   "tls",
+			server: &tls.Config{Certificates: []tls.Certificate{serverCert}},
+			client: &tls.Config{RootCAs: ca.pool},
+			ok:     true,
+		},
+		{
+			name:   "unknown ca",
+			server: &tls.Config{Certificates: []tls.Certificate{serverCert}},
+			client: &tls.Config{RootCAs: other.pool},
+		},
+		{
+			name:   "wrong name",
+			server: &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "elsewhere")}},
+			client: &tls.Config{RootCAs: ca.pool},
+		},
+		{
+			name:   "mutual",
+			server: &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: ca.pool},
+			client: &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{clientCert}},
+			ok:     true,
+		},
+		{
+			name:   "mutual without client cert",
+			server: &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: ca.pool},
+			client: &tls.Config{RootCAs: ca.pool},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			l := tlsBroker(t, tc.server)
+			defer l.Close()
+
+			var authenticated bool
+			c := &Client{
+				Bootstrap: []string{net.JoinHostPort("broker", "0")},
+				ClientID:  "test",
+				TLSConfig: tc.client,
+				Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, network, l.Addr().String())
+				},
+				Authenticate: func(ctx context.Context, cn *Conn) error {
+					_, authenticated = cn.ConnectionState()
+					return nil
+				},
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cn, err := c.Conn(ctx, 1)
+			if !tc.ok {
+				if err == nil {
+					t.Fatal("connected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			st, ok := cn.ConnectionState()
+			if !ok || !st.HandshakeComplete || st.ServerName != "broker" {
+				t.Fatalf("connection state %v %+v", ok, st)
+			}
+			if !authenticated {
+				t.Error("Authenticate ran before the TLS handshake")
+			}
+			if len(st.PeerCertificates) == 0 {
+				t.Error("no server certificate")
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package kafkaproto
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FieldError describes a field whose value cannot be encoded at a version.
+type FieldError struct {
+	Path    string
+	Version int16
+	Reason  string
+
+	// Ignorable is set when the field would be silently dropped, which Kafka
+	// itself tolerates, rather than changing the meaning of the message.
+	Ignorable bool
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + " at version " + strconv.Itoa(int(e.Version)) + ": " + e.Reason
+}
+
+// ValidationError lists every problem found by a generated Validate method.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	s := make([]string, len(e))
+	for i, f := range e {
+		s[i] = f.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+type validatable interface {
+	Message
+	validate(c *validator, v int16)
+}
+
+func validateMessage(m validatable, name string, v int16) error {
+	if !m.isVersionValid(v) {
+		return ValidationError{{Path: name, Version: v, Reason: errVersion.Error()}}
+	}
+	c := validator{path: []pathElem{{name: name, index: -1}}}
+	m.validate(&c, v)
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs
+}
+
+type pathElem struct {
+	name  string
+	index int
+}
+
+// validator collects field errors, tracking the path to the current struct.
+type validator struct {
+	path []pathElem
+	errs ValidationError
+}
+
+func (c *validator) absent(name string, v int16, ignorable bool) {
+	reason := "set but not present"
+	if !ignorable {
+		reason += " and not ignorable"
+	}
+	c.report(name, v, reason, ignorable)
+}
+
+func (c *validator) null(name string, v int16) {
+	c.report(name, v, "null but not nullable", false)
+}
+
+func (c *validator) pop() {
+	c.path = c.path[:len(c.path)-1]
+}
+
+func (c *validator) push(name string, i int) {
+	c.path = append(c.path, pathElem{name: name, index: i})
+}
+
+func (c *validator) report(name string, v int16, reason string, ignorable bool) {
+	var b strings.Builder
+	for i, p := range c.path {
+		if i != 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(p.name)
+		if p.index >= 0 {
+			b.WriteByte('[')
+			b.WriteString(strconv.Itoa(p.index))
+			b.WriteByte(']')
+		}
+	}
+	b.WriteByte('.')
+	b.WriteString(name)
+	c.errs = append(c.errs, &FieldError{Path: b.String(), Version: v, Reason: reason, Ignorable: ignorable})
+}
+
+// checkConversion validates m at version v, separating the ignorable fields
+// that converting it would drop from the problems that prevent conversion.
+func checkConversion(m validatable, name string, v int16) (dropped []*FieldError, err error) {
+	errs, _ := validateMessage(m, name, v).(ValidationError)
+	var bad ValidationError
+	for _, e := range errs {
+		if e.Ignorable {
+			dropped = append(dropped, e)
+		} else {
+			bad = append(bad, e)
+		}
+	}
+	if len(bad) != 0 {
+		return dropped, bad
+	}
+	return dropped, nil
+}
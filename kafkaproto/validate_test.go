@@ -0,0 +1,77 @@
+//go:build generated
+// +build generated
+
+package kafkaproto
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	m := new(DescribeThingsRequest)
+	const s = `{"groupId":"","things":[{"name":"a","producerId":0,"flags":[]},{"name":"b","producerId":0,"flags":[1]}],"payload":"","note":"n","weight":5}`
+	if err := UnmarshalJSON([]byte(s), m, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		v     int16
+		paths []string
+		drops []bool
+	}{
+		{0, []string{"DescribeThingsRequest.Things[1].Flags", "DescribeThingsRequest.Note", "DescribeThingsRequest.Weight"}, []bool{false, true, false}},
+		{1, []string{"DescribeThingsRequest.Weight"}, []bool{false}},
+		{2, nil, nil},
+		{3, []string{"DescribeThingsRequest"}, []bool{false}},
+	}
+	for _, tc := range cases {
+		err := m.Validate(tc.v)
+		var ve ValidationError
+		if tc.paths == nil {
+			if err != nil {
+				t.Errorf("v%d: %v", tc.v, err)
+			}
+			continue
+		}
+		if !errors.As(err, &ve) {
+			t.Fatalf("v%d: %v", tc.v, err)
+		}
+		var paths []string
+		var drops []bool
+		for _, e := range ve {
+			if e.Version != tc.v {
+				t.Errorf("v%d: error at version %d", tc.v, e.Version)
+			}
+			paths = append(paths, e.Path)
+			drops = append(drops, e.Ignorable)
+		}
+		if !reflect.DeepEqual(paths, tc.paths) || !reflect.DeepEqual(drops, tc.drops) {
+			t.Errorf("v%d: %v", tc.v, err)
+		}
+	}
+}
+
+func TestValidateNestedStruct(t *testing.T) {
+	m := new(FetchResponse)
+	m.Reset()
+	p := PartitionData{}
+	p.Reset()
+	m.Responses = []FetchableTopicResponse{{Topic: "t", Partitions: []PartitionData{p}}}
+	for _, v := range []int16{11, 12} {
+		if err := m.Validate(v); err != nil {
+			t.Errorf("default at v%d: %v", v, err)
+		}
+	}
+
+	m.Responses[0].Partitions[0].DivergingEpoch.EndOffset = 10
+	if err := m.Validate(12); err != nil {
+		t.Errorf("set at v12: %v", err)
+	}
+	err := m.Validate(11)
+	var ve ValidationError
+	if !errors.As(err, &ve) || len(ve) != 1 || ve[0].Path != "FetchResponse.Responses[0].Partitions[0].DivergingEpoch" {
+		t.Fatalf("set at v11: %v", err)
+	}
+}
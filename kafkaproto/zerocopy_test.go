@@ -0,0 +1,25 @@
+//go:build generated && !lazy
+// +build generated,!lazy
+
+package kafkaproto
+
+import "testing"
+
+// TestZeroCopyHint checks that records, which the schema marks zeroCopy,
+// alias the frame they were decoded from.
+func TestZeroCopyHint(t *testing.T) {
+	var e encoder
+	testProduceRequest(1, 1, 16).encode(&e, 8)
+	m := new(ProduceRequest)
+	d := decoder{b: e.b, end: len(e.b)}
+	if err := decodeMessage(m, &d, 8, true); err != nil {
+		t.Fatal(err)
+	}
+	records := m.Topics[0].Partitions[0].Records
+	for i := range e.b {
+		e.b[i] = 'x'
+	}
+	if string(records) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("records %q do not alias the frame", records)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+// linkLazyFields replaces the arrays that the schema marks lazy with fields
+// of a view type, which the rest of the generator treats like a struct. The
+// element structs move to the common structs, so they are still declared.
+func linkLazyFields(m *schema.MessageData) {
+	var walk func(fields []*schema.Field)
+	walk = func(fields []*schema.Field) {
+		for i, f := range fields {
+			if f.Fields != nil {
+				walk(f.Fields)
+			}
+			if !f.Lazy {
+				continue
+			}
+			if !f.Type.Array || !isStructType(f.Type.Elem) {
+				panic("lazy field " + f.Name + " is not an array of structs")
+			}
+			if f.Fields != nil {
+				m.CommonStructs = append(m.CommonStructs, &schema.CommonStruct{
+					Name:     f.Type.Elem,
+					Versions: f.Versions,
+					Fields:   f.Fields,
+				})
+			}
+			a := *f
+			v := *f
+			v.Type = schema.FieldType{Elem: f.Type.Elem + "View"}
+			v.NullableVersions = nil
+			v.Fields = nil
+			v.KeyFields = nil
+			v.ViewOf = &a
+			fields[i] = &v
+		}
+	}
+
+	common := m.CommonStructs
+	walk(m.Fields)
+	for _, s := range common {
+		walk(s.Fields)
+	}
+}
+
+// lazyFields returns the arrays replaced by views in m.
+func lazyFields(m *schema.MessageData) []*schema.Field {
+	var lazy []*schema.Field
+	seen := make(map[string]bool)
+
+	var walk func(fields []*schema.Field)
+	walk = func(fields []*schema.Field) {
+		for _, f := range fields {
+			if f.Fields != nil {
+				walk(f.Fields)
+			}
+			if a := f.ViewOf; a != nil && !seen[a.Type.Elem] {
+				seen[a.Type.Elem] = true
+				lazy = append(lazy, a)
+			}
+		}
+	}
+
+	walk(m.Fields)
+	for _, s := range m.CommonStructs {
+		walk(s.Fields)
+	}
+	return lazy
+}
+
+// structFields returns the fields of the struct type named by f.
+func structFields(m *schema.MessageData, f *schema.Field) []*schema.Field {
+	if f.ViewOf != nil {
+		f = f.ViewOf
+	}
+	if f.Fields != nil {
+		return f.Fields
+	}
+	for _, s := range m.CommonStructs {
+		if s.Name == f.Type.Elem {
+			return s.Fields
+		}
+	}
+	panic("no struct " + f.Type.Elem)
+}
+
+// genLazyViews writes a view type for each lazy array of m.
+func genLazyViews(w *codegen.File, m *schema.MessageData) {
+	for _, f := range lazyFields(m) {
+		genLazyView(w, m, f)
+	}
+}
+
+func genLazyView(w *codegen.File, m *schema.MessageData, f *schema.Field) {
+	elem := f.Type.Elem
+	name := elem + "View"
+
+	w.WriteString("// ")
+	w.WriteString(name)
+	w.WriteString(" is an array of ")
+	w.WriteString(elem)
+	w.WriteString(" that is decoded lazily, one element at a time.\n")
+	w.WriteString("type ")
+	w.WriteString(name)
+	w.WriteString(" struct {\narrayView\nelems []")
+	w.WriteString(elem)
+	w.WriteString("\n}\n\n")
+
+	w.Writef("func New%s(a []%s) %s {\nreturn %s{elems: a}\n}\n\n", name, elem, name, name)
+
+	begMethod(w, name, "IsNull", "", "bool")
+	w.WriteString("if m.decoded {\nreturn m.null\n}\nreturn m.elems == nil\n")
+	endMethod(w)
+
+	begMethod(w, name, "Len", "", "int")
+	w.WriteString("if m.decoded {\nreturn len(m.ends)\n}\nreturn len(m.elems)\n")
+	endMethod(w)
+
+	w.WriteString("// At returns element i, decoding it into a new value if necessary.\n")
+	begMethod(w, name, "At", "i int", "*"+elem)
+	w.WriteString("if !m.decoded {\nreturn &m.elems[i]\n}\nx := new(")
+	w.WriteString(elem)
+	w.WriteString(")\nm.decodeAt(i, x)\nreturn x\n")
+	endMethod(w)
+
+	w.WriteString("// Range calls fn for each element until it returns false. Elements that\n")
+	w.WriteString("// need decoding are decoded into the same value for each call.\n")
+	begMethod(w, name, "Range", "fn func(i int, x *"+elem+") bool", "")
+	w.WriteString("if !m.decoded {\nfor i := range m.elems {\nif !fn(i, &m.elems[i]) {\nreturn\n}\n}\nreturn\n}\n")
+	w.WriteString("var x ")
+	w.WriteString(elem)
+	w.WriteString("\nfor i := range m.ends {\nm.decodeAt(i, &x)\nif !fn(i, &x) {\nreturn\n}\n}\n")
+	endMethod(w)
+
+	w.WriteString("// Slice returns every element, decoding them if necessary.\n")
+	begMethod(w, name, "Slice", "", "[]"+elem)
+	w.WriteString("if !m.decoded || m.null {\nreturn m.elems\n}\na := make([]")
+	w.WriteString(elem)
+	w.WriteString(", len(m.ends))\nfor i := range a {\nm.decodeAt(i, &a[i])\n}\nreturn a\n")
+	endMethod(w)
+
+	begMethod(w, name, "decodeAt", "i int, x *"+elem, "")
+	w.WriteString("d := m.elem(i)\nx.decode(&d, m.v)\n")
+	endMethod(w)
+
+	begMethod(w, name, "clear", "", "")
+	w.WriteString("m.reset()\nfor i := range m.elems {\nm.elems[i].clear()\n}\nm.elems = m.elems[:0]\n")
+	endMethod(w)
+
+	begMethod(w, name, "decode", "d *decoder, v int16", "")
+	genArrayLenDecode(w, m, f)
+	// Each element is decoded once now, into the same value, so that decoding
+	// it again when it is accessed cannot fail.
+	w.WriteString("m.elems = m.elems[:0]\nm.begin(*d, v, n)\nvar x ")
+	w.WriteString(elem)
+	w.WriteString("\nfor i := 0; i < n; i++ {\nx.decode(d, v)\nm.mark(*d)\n}\nm.end()\n")
+	endMethod(w)
+
+	begMethod(w, name, "encode", "e *encoder, v int16", "")
+	w.WriteString("n := m.Len()\n")
+	genLazyNull(w, f)
+	genFlexibleBranch(w, m, f, func(compact bool) {
+		if compact {
+			w.WriteString("e.encodeCompactArrayLen(n)\n")
+		} else {
+			w.WriteString("e.encodeArrayLen(n)\n")
+		}
+	})
+	w.WriteString("if !m.decoded {\nfor i := range m.elems {\nm.elems[i].encode(e, v)\n}\nreturn\n}\n")
+	w.WriteString("if m.v == v {\ne.write(m.b)\nreturn\n}\nvar x ")
+	w.WriteString(elem)
+	w.WriteString("\nfor i := range m.ends {\nm.decodeAt(i, &x)\nx.encode(e, v)\n}\n")
+	endMethod(w)
+
+	begMethod(w, name, "size", "v int16", "int")
+	if hasFlexibleArrayLen(m, f) {
+		w.WriteString("n := m.Len()\n")
+		genLazyNull(w, f)
+	}
+	w.WriteString("var s int\n")
+	genFlexibleBranch(w, m, f, func(compact bool) {
+		if compact {
+			w.WriteString("s = sizeUvarint(uint64(n + 1))\n")
+		} else {
+			w.WriteString("s = 4\n")
+		}
+	})
+	w.WriteString("if !m.decoded {\nfor i := range m.elems {\ns += m.elems[i].size(v)\n}\nreturn s\n}\n")
+	w.WriteString("if m.v == v {\nreturn s + len(m.b)\n}\nvar x ")
+	w.WriteString(elem)
+	w.WriteString("\nfor i := range m.ends {\nm.decodeAt(i, &x)\ns += x.size(v)\n}\nreturn s\n")
+	endMethod(w)
+
+	begMethod(w, name, "marshalJSON", "w *jsonWriter, v int16", "error")
+	if isNullable(f) {
+		w.WriteString("if m.IsNull()")
+		if !versionsCover(f.NullableVersions, f.Versions) {
+			w.WriteString(" && ")
+			genVersionCond(w, f.NullableVersions, f.Versions)
+		}
+		w.WriteString(" {\nw.writeNull()\nreturn nil\n}\n")
+	}
+	w.WriteString("var err error\nw.beginArray()\nm.Range(func(i int, x *")
+	w.WriteString(elem)
+	w.WriteString(") bool {\nerr = x.marshalJSON(w, v)\nreturn err == nil\n})\nw.endArray()\nreturn err\n")
+	endMethod(w)
+
+	begMethod(w, name, "unmarshalJSON", "a []interface{}, v int16", "error")
+	w.WriteString("m.clear()\nif a == nil {\nm.elems = nil\nreturn nil\n}\nm.elems = make([]")
+	w.WriteString(elem)
+	w.WriteString(", len(a))\nfor i, x := range a {\no, err := jsonObject(x)\nif err != nil {\nreturn err\n}\n")
+	w.WriteString("if err := m.elems[i].unmarshalJSON(o, v); err != nil {\nreturn err\n}\n}\nreturn nil\n")
+	endMethod(w)
+
+	begMethod(w, name, "format", "p *printer", "")
+	w.WriteString("p.open('[')\nm.Range(func(i int, x *")
+	w.WriteString(elem)
+	w.WriteString(") bool {\np.elem()\nx.format(p)\nreturn true\n})\np.close(']')\n")
+	endMethod(w)
+
+	begMethod(w, name, "cloneInto", "c *"+name, "")
+	w.WriteString("c.arrayView = m.arrayView.clone()\nc.elems = nil\nif m.elems != nil {\nc.elems = make([]")
+	w.WriteString(elem)
+	w.WriteString(", len(m.elems))\nfor i := range m.elems {\nm.elems[i].cloneInto(&c.elems[i])\n}\n}\n")
+	endMethod(w)
+
+	begMethod(w, name, "equal", "o *"+name+", v int16", "bool")
+	w.WriteString("if ")
+	if isNullable(f) {
+		w.WriteString("m.IsNull() != o.IsNull()")
+		if !versionsCover(f.NullableVersions, f.Versions) {
+			w.WriteString(" && (v < 0 || ")
+			genVersionCond(w, f.NullableVersions, f.Versions)
+			w.WriteByte(')')
+		}
+		w.WriteString(" || ")
+	}
+	w.WriteString("m.Len() != o.Len() {\nreturn false\n}\n")
+	w.WriteString("for i, n := 0, m.Len(); i < n; i++ {\nif !m.At(i).equal(o.At(i), v) {\nreturn false\n}\n}\nreturn true\n")
+	endMethod(w)
+
+	// Elements decoded at another version may hold fields that v lacks.
+	begMethod(w, name, "dropAbsent", "v int16", "")
+	w.WriteString("if m.decoded {\nm.elems = m.Slice()\nm.reset()\n}\n")
+	w.WriteString("for i := range m.elems {\nm.elems[i].dropAbsent(v)\n}\n")
+	endMethod(w)
+}
+
+// genLazyNull sets n to -1 if the view is null in a version that can encode
+// it.
+func genLazyNull(w *codegen.File, f *schema.Field) {
+	if !isNullable(f) {
+		return
+	}
+	w.WriteString("if m.IsNull()")
+	if !versionsCover(f.NullableVersions, f.Versions) {
+		w.WriteString(" && ")
+		genVersionCond(w, f.NullableVersions, f.Versions)
+	}
+	w.WriteString(" {\nn = -1\n}\n")
+}
+
+// skipFuncs names the functions that pass over encoded structs, and the type
+// of decoder they read from.
+type skipFuncs struct {
+	prefix  string
+	decoder string
+	done    map[string]bool
+}
+
+// genSkip writes a function passing over an encoded struct of the type named
+// by f, and those for the structs it contains.
+func genSkip(w *codegen.File, m *schema.MessageData, f *schema.Field, s *skipFuncs) {
+	name := f.Type.Elem
+	if f.ViewOf != nil {
+		name = f.ViewOf.Type.Elem
+	}
+	if s.done[name] {
+		return
+	}
+	s.done[name] = true
+	fields := structFields(m, f)
+
+	w.WriteString("func ")
+	w.WriteString(s.prefix)
+	w.WriteString(name)
+	w.WriteString("(d *")
+	w.WriteString(s.decoder)
+	w.WriteString(", v int16) {\nd.enter()\n")
+	for _, f := range fields {
+		if isTagged(f) {
+			continue
+		}
+		if versionsCover(f.Versions, m.ValidVersions) {
+			genFieldSkip(w, m, f, s.prefix)
+			continue
+		}
+		if !versionsOverlap(f.Versions, m.ValidVersions) {
+			continue
+		}
+		w.WriteString("if ")
+		genVersionCond(w, f.Versions, m.ValidVersions)
+		w.WriteString(" {\n")
+		genFieldSkip(w, m, f, s.prefix)
+		w.WriteString("}\n")
+	}
+	genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "d.skipTaggedFields()\n")
+	w.WriteString("d.leave()\n}\n\n")
+
+	for _, f := range fields {
+		if isStructType(f.Type.Elem) {
+			genSkip(w, m, f, s)
+		}
+	}
+}
+
+func genFieldSkip(w *codegen.File, m *schema.MessageData, f *schema.Field, prefix string) {
+	if f.ViewOf != nil {
+		f = f.ViewOf
+	}
+	t := &f.Type
+
+	if !t.Array {
+		genFlexibleBranch(w, m, f, func(compact bool) {
+			genSkipValue(w, t.Elem, compact, prefix)
+		})
+		return
+	}
+
+	genFlexibleBranch(w, m, f, func(compact bool) {
+		arrayLen := "d.decodeArrayLen()"
+		if compact {
+			arrayLen = "d.decodeCompactArrayLen()"
+		}
+		if size := fixedSize(t.Elem); size != "" {
+			w.WriteString("d.skip(")
+			w.WriteString(size)
+			w.WriteString(" * ")
+			w.WriteString(arrayLen)
+			w.WriteString(")\n")
+			return
+		}
+		w.WriteString("for n := ")
+		w.WriteString(arrayLen)
+		w.WriteString("; n > 0; n-- {\n")
+		genSkipValue(w, t.Elem, compact && hasCompactForm(t.Elem), prefix)
+		w.WriteString("}\n")
+	})
+}
+
+func genSkipValue(w *codegen.File, t string, compact bool, prefix string) {
+	if size := fixedSize(t); size != "" {
+		w.WriteString("d.skip(")
+		w.WriteString(size)
+		w.WriteString(")\n")
+		return
+	}
+	switch {
+	case isStructType(t):
+		w.WriteString(prefix)
+		w.WriteString(t)
+		w.WriteString("(d, v)\n")
+	case compact:
+		w.WriteString("d.skipCompact()\n")
+	case t == "string":
+		w.WriteString("d.skipString()\n")
+	default:
+		w.WriteString("d.skipBytes()\n")
+	}
+}
+
+// fixedSize returns the encoded size of the type t, if it has only one.
+func fixedSize(t string) string {
+	switch t {
+	case "bool", "boolean", "int8":
+		return "1"
+	case "int16":
+		return "2"
+	case "int32":
+		return "4"
+	case "int64":
+		return "8"
+	}
+	return ""
+}
@@ -1,17 +1,29 @@
 package main
 
 import (
+	"flag"
 	"log"
-	"os"
 )
 
+var bench = flag.Bool("bench", false, "generate benchmarks for every message")
+
+var tables = flag.Bool("tables", false, "generate field tables for a shared codec instead of methods for each field")
+
+var copyBytes = flag.Bool("copybytes", false, "copy decoded bytes fields out of the frame unless the schema marks them zeroCopy")
+
+var zeroCopy = flag.Bool("zerocopy", false, "decode strings as aliases of the frame instead of copying them, so that decoded messages must not outlive the frame unless cloned")
+
+var inline = make(apiKeySet)
+
 func main() {
 	log.SetFlags(0)
+	flag.Var(inline, "inline", "comma-separated api keys to generate straight-line codecs for")
+	flag.Parse()
 	g := &pkgGenerator{
-		dst: os.Args[1],
+		dst: flag.Arg(0),
 		api: make(map[int16]*apiGenerator),
 	}
-	for _, src := range os.Args[2:] {
+	for _, src := range flag.Args()[1:] {
 		g.wg.Add(1)
 		go g.addFile(src)
 	}
@@ -5,7 +5,6 @@ import (
 
 	"github.com/betawaffle/kafka-gen-go/codegen"
 	"github.com/betawaffle/kafka-gen-go/schema"
-	"github.com/iancoleman/strcase"
 )
 
 func begMethod(w *codegen.File, recv, name, args, rets string) {
@@ -29,17 +28,51 @@ func endMethod(w *codegen.File) {
 	w.WriteString("}\n\n")
 }
 
-func genAssignFromDecoder(w *codegen.File, t string) {
-	if c := t[0]; c < 'a' || c > 'z' {
+func genArrayLenDecode(w *codegen.File, m *schema.MessageData, f *schema.Field) {
+	flex := flexibleVersions(m, f)
+	switch {
+	case !versionsOverlap(flex, f.Versions):
+		w.WriteString("n := d.decodeArrayLen()\n")
+	case versionsCover(flex, f.Versions):
+		w.WriteString("n := d.decodeCompactArrayLen()\n")
+	default:
+		w.WriteString("var n int\nif ")
+		genVersionCond(w, flex, f.Versions)
+		w.WriteString(" {\nn = d.decodeCompactArrayLen()\n} else {\nn = d.decodeArrayLen()\n}\n")
+	}
+}
+
+func genAssignFromDecoder(w *codegen.File, t, conv string, compact, nullable bool) {
+	if isStructType(t) {
 		w.WriteString(".decode(d, v)\n")
 		return
 	}
-	w.WriteString(" = d.decode")
-	genCoderName(w, t)
-	w.WriteString("()\n")
+	w.WriteString(" = ")
+	if conv != "" {
+		w.WriteString(conv)
+		w.WriteByte('(')
+	}
+	w.WriteString("d.decode")
+	genCoderName(w, t, compact, nullable)
+	if t == "string" && *zeroCopy {
+		w.WriteString("NoCopy")
+	}
+	w.WriteString("()")
+	if conv != "" {
+		w.WriteByte(')')
+	}
+	w.WriteByte('\n')
 }
 
-func genCoderName(w *codegen.File, t string) {
+func genCoderName(w *codegen.File, t string, compact, nullable bool) {
+	if hasCompactForm(t) {
+		if compact {
+			w.WriteString("Compact")
+		}
+		if nullable {
+			w.WriteString("Nullable")
+		}
+	}
 	switch t {
 	case "bool", "boolean":
 		w.WriteString("Bool")
@@ -53,13 +86,26 @@ func genCoderName(w *codegen.File, t string) {
 		w.WriteString("Int64")
 	case "string":
 		w.WriteString("String")
-	case "bytes":
+	case "bytes", "records":
 		w.WriteString("Bytes")
 	default:
 		panic("no coder for " + t)
 	}
 }
 
+func genEncodeValue(w *codegen.File, t, val string, compact, nullable bool) {
+	if isStructType(t) {
+		w.WriteString(val)
+		w.WriteString(".encode(e, v)\n")
+		return
+	}
+	w.WriteString("e.encode")
+	genCoderName(w, t, compact, nullable)
+	w.WriteByte('(')
+	w.WriteString(val)
+	w.WriteString(")\n")
+}
+
 func genFieldDecl(w *codegen.File, f *schema.Field) {
 	if desc := f.Desc; len(desc) != 0 {
 		w.WriteString("// ")
@@ -71,46 +117,54 @@ func genFieldDecl(w *codegen.File, f *schema.Field) {
 
 	w.WriteByte(' ')
 
-	if f.Type.Array {
-		w.WriteString("[]")
-	}
-	elem := f.Type.Elem
-	if elem == "bytes" {
-		elem = "[]byte"
-	}
-	w.WriteString(elem)
+	genFieldType(w, f)
 
 	w.WriteString(" `json:")
-	w.WriteQuoted(strcase.ToSnake(f.Name))
+	w.WriteQuoted(jsonName(f))
 	w.WriteString("`\n")
 }
 
-func genFieldDecode(w *codegen.File, recv string, f *schema.Field) {
-	v := f.Versions
+func genFieldDecode(w *codegen.File, m *schema.MessageData, recv string, f *schema.Field) {
 	t := &f.Type
 
 	begMethod(w, recv, "decode"+f.Name, "d *decoder, v int16", "")
-
-	w.WriteString("if v < ")
-	w.WriteInt(int64(v.Min), 10)
-	if v.Max != -1 {
-		w.WriteString(" || v > ")
-		w.WriteInt(int64(v.Max), 10)
-	}
-	w.WriteString(" {\nreturn\n}\n")
+	genVersionCheck(w, f.Versions, "")
 
 	if t.Array {
-		w.WriteString("a := make([]")
-		w.WriteString(t.Elem)
-		w.WriteString(", d.decodeInt32())\nfor i := range a {\na[i]")
-		genAssignFromDecoder(w, t.Elem)
-		w.WriteString("}\nm.")
+		genArrayLenDecode(w, m, f)
+		if isNullable(f) {
+			w.WriteString("if n < 0 {\nm.")
+			w.WriteString(f.Name)
+			w.WriteString(" = nil\nreturn\n}\n")
+		}
+		// Reuse the capacity, and the elements, left by an earlier decode.
+		w.WriteString("a := m.")
 		w.WriteString(f.Name)
-		w.WriteString(" = a\n")
-	} else {
+		w.WriteString("\nif cap(a) < n {\na = make(")
+		genFieldType(w, f)
+		w.WriteString(", n)\n} else {\na = a[:n]\n}\nfor i := range a {\n")
+		if hasCompactForm(t.Elem) {
+			genFlexibleBranch(w, m, f, func(compact bool) {
+				w.WriteString("a[i]")
+				genAssignFromDecoder(w, t.Elem, decodeConv(f), compact, false)
+			})
+		} else {
+			w.WriteString("a[i]")
+			genAssignFromDecoder(w, t.Elem, decodeConv(f), false, false)
+		}
+		w.WriteString("}\n")
+		if len(f.KeyFields) != 0 {
+			genDuplicateCheck(w, f)
+		}
 		w.WriteString("m.")
 		w.WriteString(f.Name)
-		genAssignFromDecoder(w, t.Elem)
+		w.WriteString(" = a\n")
+	} else {
+		genFlexibleBranch(w, m, f, func(compact bool) {
+			w.WriteString("m.")
+			w.WriteString(f.Name)
+			genAssignFromDecoder(w, t.Elem, decodeConv(f), compact, isNullable(f))
+		})
 	}
 
 	endMethod(w)
@@ -131,56 +185,122 @@ func genFieldDefault(w *codegen.File, f *schema.Field) {
 		}
 		w.WriteInt(f.Default.Integer(int(i)), 10)
 	case "string":
+		if isNullable(f) {
+			if s := f.Default.String(); !f.Default.Null() && s != "" {
+				panic("non-empty default for nullable string " + f.Name)
+			}
+			w.WriteString("nil")
+			return
+		}
+		if f.Default.Null() {
+			w.WriteString(`""`)
+			return
+		}
 		w.WriteQuoted(f.Default.String())
 	default:
+		if isStructType(t) {
+			w.WriteString(t)
+			w.WriteString("{}")
+			return
+		}
 		w.WriteString("nil")
 	}
 }
 
-func genFieldEncode(w *codegen.File, recv string, f *schema.Field) {
-	v := f.Versions
+func genFieldEncode(w *codegen.File, m *schema.MessageData, recv string, f *schema.Field) {
 	t := &f.Type
 
 	begMethod(w, recv, "encode"+f.Name, "e *encoder, v int16", "")
-
-	w.WriteString("if v < ")
-	w.WriteInt(int64(v.Min), 10)
-	if v.Max != -1 {
-		w.WriteString(" || v > ")
-		w.WriteInt(int64(v.Max), 10)
-	}
-	w.WriteString(" {\nreturn\n}\n")
+	genVersionCheck(w, f.Versions, "")
 
 	if t.Array {
 		w.WriteString("a := m.")
 		w.WriteString(f.Name)
-		w.WriteString("\nfor i := range a {\n")
-		if c := t.Elem[0]; c < 'a' || c > 'z' {
-			w.WriteString("a[i].encode(e, v)\n")
-		} else {
+		w.WriteString("\nn := len(a)\n")
+		if isNullable(f) {
+			w.WriteString("if a == nil")
+			if !versionsCover(f.NullableVersions, f.Versions) {
+				w.WriteString(" && ")
+				genVersionCond(w, f.NullableVersions, f.Versions)
+			}
+			w.WriteString(" {\nn = -1\n}\n")
+		}
+		genFlexibleBranch(w, m, f, func(compact bool) {
 			w.WriteString("e.encode")
-			genCoderName(w, t.Elem)
-			w.WriteString("(a[i])")
+			if compact {
+				w.WriteString("Compact")
+			}
+			w.WriteString("ArrayLen(n)\n")
+		})
+		w.WriteString("for i := range a {\n")
+		if hasCompactForm(t.Elem) {
+			genFlexibleBranch(w, m, f, func(compact bool) {
+				genEncodeValue(w, t.Elem, baseValue(f, "a[i]"), compact, false)
+			})
+		} else {
+			genEncodeValue(w, t.Elem, baseValue(f, "a[i]"), false, false)
 		}
 		w.WriteString("}\n")
 	} else {
-		w.WriteString("e.encode")
-		genCoderName(w, t.Elem)
-		w.WriteString("(m.")
-		w.WriteString(f.Name)
-		w.WriteString(")\n")
+		genFlexibleBranch(w, m, f, func(compact bool) {
+			if t.Elem != "string" {
+				genVersionBranch(w, f.NullableVersions, f.Versions, func(nullable bool) {
+					genEncodeValue(w, t.Elem, baseValue(f, "m."+f.Name), compact, nullable)
+				})
+				return
+			}
+			genEncodeValue(w, t.Elem, baseValue(f, "m."+f.Name), compact, isNullable(f))
+		})
 	}
 
 	endMethod(w)
 }
 
+func genFieldNonDefault(w *codegen.File, f *schema.Field) {
+	switch t := f.Type.Elem; {
+	case f.ViewOf != nil:
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(".Len() != 0")
+	case f.Type.Array, t == "bytes", t == "records":
+		w.WriteString("len(m.")
+		w.WriteString(f.Name)
+		w.WriteString(") != 0")
+	case isStructType(t):
+		w.WriteString("true")
+	default:
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(" != ")
+		genFieldDefault(w, f)
+	}
+}
+
+func genFieldType(w *codegen.File, f *schema.Field) {
+	w.WriteString(fieldType(f))
+}
+
+// genFlexibleBranch calls fn for the compact and/or classic encodings that f
+// uses, branching on the version when it uses both.
+func genFlexibleBranch(w *codegen.File, m *schema.MessageData, f *schema.Field, fn func(compact bool)) {
+	if !f.Type.Array && !hasCompactForm(f.Type.Elem) {
+		fn(false)
+		return
+	}
+	genVersionBranch(w, flexibleVersions(m, f), f.Versions, fn)
+}
+
 func genMessage(w *codegen.File, m *schema.MessageData) {
+	linkLazyFields(m)
+
 	w.Write(m.Comments)
 	genStructDecl(w, m, m.Name, m.Fields)
 
 	for _, s := range m.CommonStructs {
 		genStructDecl(w, m, s.Name, s.Fields)
 	}
+	genLazyViews(w, m)
+	genStreams(w, m)
 }
 
 func genMessageMethods(w *codegen.File, m *schema.MessageData) {
@@ -196,6 +316,23 @@ func genMessageMethods(w *codegen.File, m *schema.MessageData) {
 	w.WriteByte('\n')
 	endMethod(w)
 
+	begMethod(w, m.Name, "Validate", "v int16", "error")
+	w.WriteString("return validateMessage(m, ")
+	w.WriteQuoted(m.Name)
+	w.WriteString(", v)\n")
+	endMethod(w)
+
+	begMethod(w, m.Name, "Size", "v int16", "int")
+	w.WriteString("return m.size(v)\n")
+	endMethod(w)
+
+	begMethod(w, m.Name, "AppendTo", "dst []byte, v int16", "[]byte")
+	w.WriteString("return appendMessage(dst, m, v)\n")
+	endMethod(w)
+
+	genMessageConvert(w, m)
+	genMessagePool(w, m)
+
 	switch m.Type {
 	case "header":
 		// Nothing.
@@ -225,8 +362,34 @@ func genStructDecl(w *codegen.File, m *schema.MessageData, name string, fields [
 	w.WriteString("}\n\n")
 
 	genStructReset(w, name, fields)
-	genStructDecode(w, m, name, fields)
-	genStructEncode(w, m, name, fields)
+	genStructClear(w, m, name, fields)
+	switch {
+	case isInlined(m):
+		genStructInline(w, m, name, fields)
+	case *tables:
+		genStructTable(w, m, name, fields)
+	default:
+		genStructDecode(w, m, name, fields)
+		genStructEncode(w, m, name, fields)
+		genStructSize(w, m, name, fields)
+	}
+	genStructMarshalJSON(w, m, name, fields)
+	genStructUnmarshalJSON(w, m, name, fields)
+	genStructFormat(w, name, fields)
+	genStructClone(w, name, fields)
+	genStructEqual(w, m, name, fields)
+	genStructValidate(w, m, name, fields)
+	genStructDropAbsent(w, m, name, fields)
+
+	var keys []*schema.Field
+	for _, f := range fields {
+		if f.MapKey {
+			keys = append(keys, f)
+		}
+	}
+	if len(keys) != 0 {
+		genCollection(w, name, keys)
+	}
 
 	if name == m.Name {
 		genMessageMethods(w, m)
@@ -247,43 +410,189 @@ func genStructDecode(w *codegen.File, m *schema.MessageData, recv string, fields
 	// 	w.WriteString("if !m.isVersionValid(v) {\npanic(errVersion)\n}\n")
 	// }
 
-	w.WriteString("m.Reset()\n")
+	w.WriteString("d.enter()\nm.clear()\n")
 
+	var tagged bool
 	for _, f := range fields {
+		if isTagged(f) {
+			tagged = true
+			continue
+		}
 		w.WriteString("m.decode")
 		w.WriteString(f.Name)
 		w.WriteString("(d, v)\n")
 	}
 
+	if tagged {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "m.decodeTaggedFields(d, v)\n")
+	} else {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "d.skipUnknownTaggedFields()\n")
+	}
+	w.WriteString("d.leave()\n")
+
 	endMethod(w)
 
 	for _, f := range fields {
-		genFieldDecode(w, recv, f)
+		genFieldDecode(w, m, recv, f)
+	}
+
+	if tagged {
+		genStructDecodeTags(w, recv, fields)
+	}
+}
+
+func genStructDecodeTags(w *codegen.File, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "decodeTaggedFields", "d *decoder, v int16", "")
+
+	w.WriteString("for n := d.decodeTaggedFieldCount(); n > 0; n-- {\n")
+	w.WriteString("t := d.decodeTag()\n")
+	w.WriteString("b := d.decodeTaggedField()\n")
+	w.WriteString("switch t {\n")
+
+	for _, f := range fields {
+		if !isTagged(f) {
+			continue
+		}
+		w.WriteString("case ")
+		w.WriteInt(int64(*f.Tag), 10)
+		w.WriteString(":\n")
+		w.WriteString("m.decode")
+		w.WriteString(f.Name)
+		w.WriteString("(&b, v)\nb.taggedFieldDone()\n")
 	}
+
+	w.WriteString("default:\nb.unknownTag(t)\n}\n}\n")
+
+	endMethod(w)
 }
 
 func genStructEncode(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
 	begMethod(w, recv, "encode", "e *encoder, v int16", "")
 
+	var tagged bool
 	for _, f := range fields {
+		if isTagged(f) {
+			tagged = true
+			continue
+		}
 		w.WriteString("m.encode")
 		w.WriteString(f.Name)
 		w.WriteString("(e, v)\n")
 	}
 
+	if tagged {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "m.encodeTaggedFields(e, v)\n")
+	} else {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "e.encodeUvarint(0)\n")
+	}
+
 	endMethod(w)
 
 	for _, f := range fields {
-		genFieldEncode(w, recv, f)
+		genFieldEncode(w, m, recv, f)
+	}
+
+	if tagged {
+		genStructEncodeTags(w, m, recv, fields)
 	}
 }
 
+func genStructEncodeTags(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "encodeTaggedFields", "e *encoder, v int16", "")
+
+	w.WriteString("var n uint64\n")
+
+	for _, f := range fields {
+		if !isTagged(f) {
+			continue
+		}
+		genTaggedFieldCond(w, m, f)
+		w.WriteString(" {\nn++\n}\n")
+	}
+
+	w.WriteString("e.encodeUvarint(n)\n")
+
+	for _, f := range fields {
+		if !isTagged(f) {
+			continue
+		}
+		genTaggedFieldCond(w, m, f)
+		w.WriteString(" {\ne.encodeUvarint(")
+		w.WriteInt(int64(*f.Tag), 10)
+		w.WriteString(")\ne.encodeUvarint(uint64(m.size")
+		w.WriteString(f.Name)
+		w.WriteString("(v)))\nm.encode")
+		w.WriteString(f.Name)
+		w.WriteString("(e, v)\n}\n")
+	}
+
+	endMethod(w)
+}
+
+// genStructClear writes clear, which is like Reset but keeps the memory of
+// arrays and nested structs for reuse. Only arrays that decoding always sets
+// keep theirs, so that clearing leaves the same state as Reset.
+func genStructClear(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "clear", "", "")
+
+	for _, f := range fields {
+		if f.Type.Array && reusesArray(m, f) {
+			genClearElems(w, f)
+		}
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		switch {
+		case f.Type.Array && reusesArray(m, f):
+			w.WriteString(" = m.")
+			w.WriteString(f.Name)
+			w.WriteString("[:0]\n")
+		case f.Type.Array:
+			w.WriteString(" = nil\n")
+		case isStructType(f.Type.Elem):
+			w.WriteString(".clear()\n")
+		default:
+			w.WriteString(" = ")
+			genFieldDefault(w, f)
+			w.WriteByte('\n')
+		}
+	}
+
+	endMethod(w)
+}
+
+// genClearElems clears the elements of an array whose memory clear keeps, so
+// that it does not hold on to what they reference.
+func genClearElems(w *codegen.File, f *schema.Field) {
+	var clear string
+	switch t := f.Type.Elem; {
+	case isStructType(t):
+		clear = ".clear()\n"
+	case t == "string":
+		clear = " = \"\"\n"
+	case t == "bytes", t == "records":
+		clear = " = nil\n"
+	default:
+		return
+	}
+	w.WriteString("for i := range m.")
+	w.WriteString(f.Name)
+	w.WriteString(" {\nm.")
+	w.WriteString(f.Name)
+	w.WriteString("[i]")
+	w.WriteString(clear)
+	w.WriteString("}\n")
+}
+
 func genStructReset(w *codegen.File, recv string, fields []*schema.Field) {
 	begMethod(w, recv, "Reset", "", "")
 
 	for _, f := range fields {
 		w.WriteString("m.")
 		w.WriteString(f.Name)
+		if !f.Type.Array && f.ViewOf == nil && isStructType(f.Type.Elem) {
+			w.WriteString(".Reset()\n")
+			continue
+		}
 		w.WriteString(" = ")
 		genFieldDefault(w, f)
 		w.WriteByte('\n')
@@ -292,6 +601,53 @@ func genStructReset(w *codegen.File, recv string, fields []*schema.Field) {
 	endMethod(w)
 }
 
+func genVersionBranch(w *codegen.File, r, p *schema.VersionRange, fn func(bool)) {
+	switch {
+	case !versionsOverlap(r, p):
+		fn(false)
+	case versionsCover(r, p):
+		fn(true)
+	default:
+		w.WriteString("if ")
+		genVersionCond(w, r, p)
+		w.WriteString(" {\n")
+		fn(true)
+		w.WriteString("} else {\n")
+		fn(false)
+		w.WriteString("}\n")
+	}
+}
+
+func genVersionIf(w *codegen.File, r, p *schema.VersionRange, body string) {
+	switch {
+	case !versionsOverlap(r, p):
+	case versionsCover(r, p):
+		w.WriteString(body)
+	default:
+		w.WriteString("if ")
+		genVersionCond(w, r, p)
+		w.WriteString(" {\n")
+		w.WriteString(body)
+		w.WriteString("}\n")
+	}
+}
+
+// genVersionCheck returns ret from the method if v is outside the range.
+func genVersionCheck(w *codegen.File, v *schema.VersionRange, ret string) {
+	w.WriteString("if v < ")
+	w.WriteInt(int64(v.Min), 10)
+	if v.Max != -1 {
+		w.WriteString(" || v > ")
+		w.WriteInt(int64(v.Max), 10)
+	}
+	w.WriteString(" {\nreturn")
+	if ret != "" {
+		w.WriteByte(' ')
+		w.WriteString(ret)
+	}
+	w.WriteString("\n}\n")
+}
+
 func genVersionCond(w *codegen.File, v, p *schema.VersionRange) {
 	if v == nil {
 		if p == nil {
@@ -309,7 +665,7 @@ func genVersionCond(w *codegen.File, v, p *schema.VersionRange) {
 			panic("unexpected version range")
 		}
 		if v.Max == -1 || v.Max == p.Max {
-			if v.Min == p.Min {
+			if v.Min <= p.Min {
 				w.WriteString("true")
 			} else {
 				w.WriteString("v >= ")
@@ -317,15 +673,148 @@ func genVersionCond(w *codegen.File, v, p *schema.VersionRange) {
 			}
 			return
 		}
-		if v.Min == p.Min {
+		if v.Min <= p.Min {
 			w.WriteString("v <= ")
 			w.WriteInt(int64(v.Max), 10)
 			return
 		}
 	}
+	if v.Max == -1 {
+		w.WriteString("v >= ")
+		w.WriteInt(int64(v.Min), 10)
+		return
+	}
 	w.WriteString("v >= ")
 	w.WriteInt(int64(v.Min), 10)
 	w.WriteString(" && v <= ")
 	w.WriteInt(int64(v.Max), 10)
 	return
 }
+
+// baseValue converts val, a value of f or an element of it, back to the schema
+// type if f has an entity type.
+func baseValue(f *schema.Field, val string) string {
+	if entityType(f) == "" {
+		return val
+	}
+	if !f.Type.Array && f.Type.Elem == "string" && isNullable(f) {
+		return "(*string)(" + val + ")"
+	}
+	return f.Type.Elem + "(" + val + ")"
+}
+
+// decodeConv returns the conversion applied to decoded values of f. Bytes
+// alias the frame unless the -copybytes flag asks for copies, which the
+// schema or the -zerocopy flag can still opt out of.
+func decodeConv(f *schema.Field) string {
+	if f.Type.Elem == "bytes" && *copyBytes && !f.ZeroCopy && !*zeroCopy {
+		return "copyBytes"
+	}
+	return entityConv(f)
+}
+
+// entityConv returns the conversion from the schema type to the Go type of f,
+// if they differ.
+func entityConv(f *schema.Field) string {
+	et := entityType(f)
+	if et != "" && !f.Type.Array && f.Type.Elem == "string" && isNullable(f) {
+		return "(*" + et + ")"
+	}
+	return et
+}
+
+// entityTypes maps schema entity types to the runtime types used for them,
+// along with the schema type they must be used with.
+var entityTypes = map[string][2]string{
+	"brokerId":        {"BrokerID", "int32"},
+	"groupId":         {"GroupID", "string"},
+	"producerId":      {"ProducerID", "int64"},
+	"topicName":       {"TopicName", "string"},
+	"transactionalId": {"TransactionalID", "string"},
+}
+
+func entityType(f *schema.Field) string {
+	e, ok := entityTypes[f.EntityType]
+	if !ok {
+		return ""
+	}
+	if e[1] != f.Type.Elem {
+		panic("entity type " + f.EntityType + " used with " + f.Type.Elem + " field " + f.Name)
+	}
+	return e[0]
+}
+
+func fieldType(f *schema.Field) string {
+	elem := f.Type.Elem
+	if et := entityType(f); et != "" {
+		elem = et
+	}
+	switch f.Type.Elem {
+	case "bytes", "records":
+		elem = "[]byte"
+	case "string":
+		if !f.Type.Array && isNullable(f) {
+			elem = "*" + elem
+		}
+	}
+	if !f.Type.Array {
+		return elem
+	}
+	if len(f.KeyFields) != 0 {
+		return elem + "Collection"
+	}
+	return "[]" + elem
+}
+
+func flexibleVersions(m *schema.MessageData, f *schema.Field) *schema.VersionRange {
+	if f.FlexibleVersions != nil {
+		return f.FlexibleVersions
+	}
+	return m.FlexibleVersions
+}
+
+func hasCompactForm(t string) bool {
+	switch t {
+	case "string", "bytes", "records":
+		return true
+	}
+	return false
+}
+
+func isNullable(f *schema.Field) bool {
+	return f.NullableVersions != nil && f.NullableVersions.Min != -1
+}
+
+func isStructType(t string) bool {
+	c := t[0]
+	return c < 'a' || c > 'z'
+}
+
+// reusesArray reports whether f is an array that decoding sets in every
+// version and never to null, so that its memory can be kept between decodes.
+func reusesArray(m *schema.MessageData, f *schema.Field) bool {
+	return versionsCover(f.Versions, m.ValidVersions) && !isNullable(f) && !isTagged(f)
+}
+
+func isTagged(f *schema.Field) bool {
+	return f.Tag != nil && f.TaggedVersions != nil && f.TaggedVersions.Min != -1
+}
+
+// versionsCover reports whether every version in p is also in r.
+func versionsCover(r, p *schema.VersionRange) bool {
+	if r == nil || r.Min == -1 || r.Min > p.Min {
+		return false
+	}
+	return r.Max == -1 || p.Max != -1 && r.Max >= p.Max
+}
+
+// versionsOverlap reports whether any version in p is also in r.
+func versionsOverlap(r, p *schema.VersionRange) bool {
+	if r == nil || r.Min == -1 {
+		return false
+	}
+	if p.Max != -1 && r.Min > p.Max {
+		return false
+	}
+	return r.Max == -1 || r.Max >= p.Min
+}
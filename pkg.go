@@ -3,6 +3,8 @@ package main
 import (
 	"log"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/betawaffle/kafka-gen-go/codegen"
@@ -12,6 +14,7 @@ import (
 type genImpl interface {
 	addMessage(*schema.MessageData) bool
 	getFileName() string
+	getMessages() []*schema.MessageData
 	run(*codegen.File)
 }
 
@@ -48,6 +51,15 @@ func (g *pkgGenerator) addFile(src string) {
 	f := codegen.NewFile(filepath.Join(g.dst, impl.getFileName()))
 	impl.run(f)
 
+	if err = f.Flush(); err != nil {
+		log.Print(err)
+	}
+	if !*bench {
+		return
+	}
+	f = codegen.NewFile(filepath.Join(g.dst, strings.TrimSuffix(impl.getFileName(), ".go")+"_test.go"))
+	genBenchmarks(f, impl.getMessages()...)
+
 	if err = f.Flush(); err != nil {
 		log.Print(err)
 	}
@@ -66,4 +78,59 @@ func (g *pkgGenerator) getAPI(key int16) *apiGenerator {
 }
 
 func (g *pkgGenerator) finish() {
+	f := codegen.NewFile(filepath.Join(g.dst, "apis_gen.go"))
+	g.genRegistry(f)
+
+	if err := f.Flush(); err != nil {
+		log.Print(err)
+	}
+	if !*bench {
+		return
+	}
+	f = codegen.NewFile(filepath.Join(g.dst, "bench_gen_test.go"))
+	genBenchHelpers(f)
+
+	if err := f.Flush(); err != nil {
+		log.Print(err)
+	}
+}
+
+func (g *pkgGenerator) genRegistry(w *codegen.File) {
+	keys := make([]int, 0, len(g.api))
+	for k, a := range g.api {
+		if a.req != nil && a.res != nil {
+			keys = append(keys, int(k))
+		}
+	}
+	sort.Ints(keys)
+
+	w.WriteString("func init() {\n")
+
+	if g.hdr.req != nil {
+		w.WriteString("newRequestHeader = func() Message {\nreturn AcquireRequestHeader()\n}\n")
+	}
+	if g.hdr.res != nil {
+		w.WriteString("newResponseHeader = func() Message {\nreturn AcquireResponseHeader()\n}\n")
+	}
+
+	w.WriteString("apis = map[int16]*API{\n")
+	for _, k := range keys {
+		a := g.api[int16(k)]
+		v := a.req.ValidVersions
+		w.WriteInt(int64(k), 10)
+		w.WriteString(": {\nKey: ")
+		w.WriteInt(int64(k), 10)
+		w.WriteString(",\nName: ")
+		w.WriteQuoted(strings.TrimSuffix(a.req.Name, "Request"))
+		w.WriteString(",\nMinVersion: ")
+		w.WriteInt(int64(v.Min), 10)
+		w.WriteString(",\nMaxVersion: ")
+		w.WriteInt(int64(v.Max), 10)
+		w.WriteString(",\nnewRequest: func() Message {\nreturn Acquire")
+		w.WriteString(a.req.Name)
+		w.WriteString("()\n},\nnewResponse: func() Message {\nreturn Acquire")
+		w.WriteString(a.res.Name)
+		w.WriteString("()\n},\n},\n")
+	}
+	w.WriteString("}\n}\n")
 }
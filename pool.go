@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+// genMessagePool writes a pool of messages, so that decoding a stream of them
+// can reuse the arrays of earlier ones.
+func genMessagePool(w *codegen.File, m *schema.MessageData) {
+	pool := strings.ToLower(m.Name[:1]) + m.Name[1:] + "Pool"
+
+	w.WriteString("var ")
+	w.WriteString(pool)
+	w.WriteString(" = sync.Pool{\nNew: func() interface{} {\nreturn new(")
+	w.WriteString(m.Name)
+	w.WriteString(")\n},\n}\n\n")
+
+	w.WriteString("func Acquire")
+	w.WriteString(m.Name)
+	w.WriteString("() *")
+	w.WriteString(m.Name)
+	w.WriteString(" {\nm := ")
+	w.WriteString(pool)
+	w.WriteString(".Get().(*")
+	w.WriteString(m.Name)
+	w.WriteString(")\nm.clear()\nreturn m\n}\n\n")
+
+	// Clearing before the message is pooled lets go of the frames and records
+	// it references.
+	begMethod(w, m.Name, "Release", "", "")
+	w.WriteString("m.clear()\n")
+	w.WriteString(pool)
+	w.WriteString(".Put(m)\n")
+	endMethod(w)
+}
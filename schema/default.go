@@ -58,6 +58,10 @@ func (v *Default) Integer(bitSize int) int64 {
 	return i
 }
 
+func (v *Default) Null() bool {
+	return v.set && v.typ == jsonString && v.str == "null"
+}
+
 func (v *Default) String() string {
 	if !v.set {
 		return ""
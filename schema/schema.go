@@ -25,6 +25,14 @@ type Field struct {
 	EntityType       string        `json:"entityType"`
 	MapKey           bool          `json:"mapKey"`
 	Ignorable        bool          `json:"ignorable"`
+	ZeroCopy         bool          `json:"zeroCopy"`
+	Lazy             bool          `json:"lazy"`
+
+	// KeyFields are the mapKey fields of the elements of an array field.
+	KeyFields []*Field `json:"-"`
+
+	// ViewOf is the lazy array field that a view field replaces.
+	ViewOf *Field `json:"-"`
 }
 
 type FieldType struct {
@@ -58,9 +66,40 @@ func ReadMessageData(filename string) (*MessageData, error) {
 		return nil, err
 	}
 	msg.applyHacks()
+	msg.linkKeyFields()
 	return msg, nil
 }
 
+func (m *MessageData) linkKeyFields() {
+	structs := make(map[string][]*Field, len(m.CommonStructs))
+	for _, s := range m.CommonStructs {
+		structs[s.Name] = s.Fields
+	}
+
+	var link func(fields []*Field)
+	link = func(fields []*Field) {
+		for _, f := range fields {
+			if f.Type.Array {
+				elem := f.Fields
+				if elem == nil {
+					elem = structs[f.Type.Elem]
+				}
+				for _, k := range elem {
+					if k.MapKey {
+						f.KeyFields = append(f.KeyFields, k)
+					}
+				}
+			}
+			link(f.Fields)
+		}
+	}
+
+	link(m.Fields)
+	for _, s := range m.CommonStructs {
+		link(s.Fields)
+	}
+}
+
 type VersionRange struct {
 	Min int16
 	Max int16
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+func genFieldSize(w *codegen.File, m *schema.MessageData, recv string, f *schema.Field) {
+	t := &f.Type
+
+	begMethod(w, recv, "size"+f.Name, "v int16", "int")
+	genVersionCheck(w, f.Versions, "0")
+
+	if !t.Array {
+		genFlexibleBranch(w, m, f, func(compact bool) {
+			if t.Elem != "string" {
+				genVersionBranch(w, f.NullableVersions, f.Versions, func(nullable bool) {
+					w.WriteString("return ")
+					genSizeValue(w, t.Elem, baseValue(f, "m."+f.Name), compact, nullable)
+					w.WriteByte('\n')
+				})
+				return
+			}
+			w.WriteString("return ")
+			genSizeValue(w, t.Elem, baseValue(f, "m."+f.Name), compact, isNullable(f))
+			w.WriteByte('\n')
+		})
+		endMethod(w)
+		return
+	}
+
+	w.WriteString("a := m.")
+	w.WriteString(f.Name)
+	w.WriteString("\n")
+	// Only the compact length depends on the number of elements.
+	if hasFlexibleArrayLen(m, f) {
+		w.WriteString("n := len(a)\n")
+		if isNullable(f) {
+			w.WriteString("if a == nil")
+			if !versionsCover(f.NullableVersions, f.Versions) {
+				w.WriteString(" && ")
+				genVersionCond(w, f.NullableVersions, f.Versions)
+			}
+			w.WriteString(" {\nn = -1\n}\n")
+		}
+	}
+	w.WriteString("var s int\n")
+	genFlexibleBranch(w, m, f, func(compact bool) {
+		if compact {
+			w.WriteString("s = sizeUvarint(uint64(n + 1))\n")
+		} else {
+			w.WriteString("s = 4\n")
+		}
+	})
+
+	switch t.Elem {
+	case "bool", "boolean", "int8":
+		w.WriteString("s += len(a)\n")
+	case "int16":
+		w.WriteString("s += 2 * len(a)\n")
+	case "int32":
+		w.WriteString("s += 4 * len(a)\n")
+	case "int64":
+		w.WriteString("s += 8 * len(a)\n")
+	default:
+		w.WriteString("for i := range a {\n")
+		if hasCompactForm(t.Elem) {
+			genFlexibleBranch(w, m, f, func(compact bool) {
+				w.WriteString("s += ")
+				genSizeValue(w, t.Elem, baseValue(f, "a[i]"), compact, false)
+				w.WriteByte('\n')
+			})
+		} else {
+			w.WriteString("s += ")
+			genSizeValue(w, t.Elem, baseValue(f, "a[i]"), false, false)
+			w.WriteByte('\n')
+		}
+		w.WriteString("}\n")
+	}
+
+	w.WriteString("return s\n")
+	endMethod(w)
+}
+
+// genSizeValue writes an expression for the encoded size of val.
+func genSizeValue(w *codegen.File, t, val string, compact, nullable bool) {
+	switch t {
+	case "bool", "boolean", "int8":
+		w.WriteString("1")
+	case "int16":
+		w.WriteString("2")
+	case "int32":
+		w.WriteString("4")
+	case "int64":
+		w.WriteString("8")
+	default:
+		if isStructType(t) {
+			w.WriteString(val)
+			w.WriteString(".size(v)")
+			return
+		}
+		w.WriteString("size")
+		genCoderName(w, t, compact, nullable)
+		w.WriteByte('(')
+		w.WriteString(val)
+		w.WriteByte(')')
+	}
+}
+
+func genStructSize(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "size", "v int16", "int")
+	w.WriteString("var s int\n")
+
+	var tagged bool
+	for _, f := range fields {
+		if isTagged(f) {
+			tagged = true
+			continue
+		}
+		w.WriteString("s += m.size")
+		w.WriteString(f.Name)
+		w.WriteString("(v)\n")
+	}
+
+	if tagged {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "s += m.sizeTaggedFields(v)\n")
+	} else {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "s++\n")
+	}
+
+	w.WriteString("return s\n")
+	endMethod(w)
+
+	for _, f := range fields {
+		genFieldSize(w, m, recv, f)
+	}
+
+	if tagged {
+		genStructSizeTags(w, m, recv, fields)
+	}
+}
+
+func genStructSizeTags(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "sizeTaggedFields", "v int16", "int")
+	w.WriteString("var n uint64\nvar s int\n")
+
+	for _, f := range fields {
+		if !isTagged(f) {
+			continue
+		}
+		genTaggedFieldCond(w, m, f)
+		w.WriteString(" {\nx := m.size")
+		w.WriteString(f.Name)
+		w.WriteString("(v)\ns += sizeUvarint(")
+		w.WriteInt(int64(*f.Tag), 10)
+		w.WriteString(") + sizeUvarint(uint64(x)) + x\nn++\n}\n")
+	}
+
+	w.WriteString("return sizeUvarint(n) + s\n")
+	endMethod(w)
+}
+
+// genTaggedFieldCond writes the start of an if statement testing whether the
+// tagged field f is written at the current version.
+func genTaggedFieldCond(w *codegen.File, m *schema.MessageData, f *schema.Field) {
+	w.WriteString("if ")
+	if !versionsCover(f.TaggedVersions, m.FlexibleVersions) {
+		genVersionCond(w, f.TaggedVersions, m.FlexibleVersions)
+		w.WriteString(" && ")
+	}
+	genFieldNonDefault(w, f)
+}
+
+// hasFlexibleArrayLen reports whether f uses a compact array length in any
+// version.
+func hasFlexibleArrayLen(m *schema.MessageData, f *schema.Field) bool {
+	return versionsOverlap(flexibleVersions(m, f), f.Versions)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+// genStreams writes the methods that decode a response from a stream, for the
+// structs of m that lead to records. Their other fields are read into memory
+// and decoded as usual, while the records are handed to the caller.
+func genStreams(w *codegen.File, m *schema.MessageData) {
+	if m.Type != "response" {
+		return
+	}
+	structs := messageStructs(m)
+	streamed := streamedStructs(structs)
+	if !streamed[m.Name] {
+		return
+	}
+	scan := &skipFuncs{prefix: "scan", decoder: "streamDecoder", done: make(map[string]bool)}
+	for _, s := range structs {
+		if streamed[s.Name] {
+			genStructStream(w, m, s.Name, s.Fields, streamed, scan)
+		}
+	}
+}
+
+// genStreamWhole writes a method that reads all of m from a stream before
+// decoding it.
+func genStreamWhole(w *codegen.File, m *schema.MessageData) {
+	scan := &skipFuncs{prefix: "scan", decoder: "streamDecoder", done: make(map[string]bool)}
+
+	begMethod(w, m.Name, "decodeStream", "d *streamDecoder, v int16", "")
+	w.WriteString("d.begin()\nscan")
+	w.WriteString(m.Name)
+	w.WriteString("(d, v)\nb := d.end()\nm.decode(&b, v)\n")
+	endMethod(w)
+
+	genSkip(w, m, &schema.Field{Name: m.Name, Type: schema.FieldType{Elem: m.Name}, Fields: m.Fields}, scan)
+}
+
+func genStructStream(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field, streamed map[string]bool, scan *skipFuncs) {
+	var read, tagged []*schema.Field
+	for _, f := range fields {
+		switch {
+		case isTagged(f):
+			tagged = append(tagged, f)
+		case !isStreamedField(f, streamed):
+			read = append(read, f)
+		}
+	}
+
+	begMethod(w, recv, "decodeStream", "d *streamDecoder, v int16", "")
+	w.WriteString("m.clear()\nd.push(m)\n")
+	if len(read) != 0 || len(tagged) != 0 {
+		w.WriteString("var b decoder\n")
+	}
+
+	// Consecutive fields that are read into memory are decoded together.
+	var run []*schema.Field
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		w.WriteString("d.begin()\n")
+		for _, f := range run {
+			if versionsCover(f.Versions, m.ValidVersions) {
+				genFieldSkip(w, m, f, scan.prefix)
+				continue
+			}
+			if !versionsOverlap(f.Versions, m.ValidVersions) {
+				continue
+			}
+			w.WriteString("if ")
+			genVersionCond(w, f.Versions, m.ValidVersions)
+			w.WriteString(" {\n")
+			genFieldSkip(w, m, f, scan.prefix)
+			w.WriteString("}\n")
+		}
+		w.WriteString("b = d.end()\n")
+		for _, f := range run {
+			w.WriteString("m.decode")
+			w.WriteString(f.Name)
+			w.WriteString("(&b, v)\n")
+		}
+		run = run[:0]
+	}
+	for _, f := range fields {
+		switch {
+		case isTagged(f):
+		case isStreamedField(f, streamed):
+			flush()
+			w.WriteString("m.stream")
+			w.WriteString(f.Name)
+			w.WriteString("(d, v)\n")
+		default:
+			run = append(run, f)
+		}
+	}
+	flush()
+
+	if len(tagged) != 0 {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "d.begin()\nd.skipTaggedFields()\nb = d.end()\nm.decodeTaggedFields(&b, v)\n")
+	} else {
+		genVersionIf(w, m.FlexibleVersions, m.ValidVersions, "d.skipUnknownTaggedFields()\n")
+	}
+	w.WriteString("d.pop()\n")
+	endMethod(w)
+
+	for _, f := range fields {
+		if !isTagged(f) && isStreamedField(f, streamed) {
+			genFieldStream(w, m, recv, f)
+		}
+	}
+
+	// The other codecs have no methods decoding single fields.
+	if isInlined(m) || *tables {
+		for _, f := range read {
+			genFieldDecode(w, m, recv, f)
+		}
+		for _, f := range tagged {
+			genFieldDecode(w, m, recv, f)
+		}
+		if len(tagged) != 0 {
+			genStructDecodeTags(w, recv, fields)
+		}
+	}
+
+	for _, f := range read {
+		if isStructType(f.Type.Elem) {
+			genSkip(w, m, f, scan)
+		}
+	}
+}
+
+func genFieldStream(w *codegen.File, m *schema.MessageData, recv string, f *schema.Field) {
+	t := &f.Type
+
+	begMethod(w, recv, "stream"+f.Name, "d *streamDecoder, v int16", "")
+	genVersionCheck(w, f.Versions, "")
+
+	switch {
+	case t.Elem == "records":
+		genFlexibleBranch(w, m, f, func(compact bool) {
+			if compact {
+				w.WriteString("d.records(d.decodeCompactLen())\n")
+			} else {
+				w.WriteString("d.records(int(d.decodeInt32()))\n")
+			}
+		})
+	case t.Array:
+		genArrayLenDecode(w, m, f)
+		if isNullable(f) {
+			w.WriteString("if n < 0 {\nm.")
+			w.WriteString(f.Name)
+			w.WriteString(" = nil\nreturn\n}\n")
+		}
+		// The length is only claimed until the elements have been read, so
+		// grow the array as they arrive.
+		w.WriteString("a := m.")
+		w.WriteString(f.Name)
+		w.WriteString("[:0]\nfor len(a) < n {\nif len(a) < cap(a) {\na = a[:len(a)+1]\n} else {\na = append(a, ")
+		w.WriteString(strings.TrimPrefix(fieldType(f), "[]"))
+		w.WriteString("{})\n}\na[len(a)-1].decodeStream(d, v)\n}\n")
+		if len(f.KeyFields) != 0 {
+			genDuplicateCheck(w, f)
+		}
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(" = a\n")
+	default:
+		w.WriteString("m.")
+		w.WriteString(f.Name)
+		w.WriteString(".decodeStream(d, v)\n")
+	}
+
+	endMethod(w)
+}
+
+// messageStructs returns m and the structs it declares, in the order they are
+// declared.
+func messageStructs(m *schema.MessageData) []*schema.CommonStruct {
+	structs := []*schema.CommonStruct{{Name: m.Name, Fields: m.Fields}}
+
+	var walk func(fields []*schema.Field)
+	walk = func(fields []*schema.Field) {
+		for _, f := range fields {
+			if f.Fields == nil {
+				continue
+			}
+			structs = append(structs, &schema.CommonStruct{Name: f.Type.Elem, Fields: f.Fields})
+			walk(f.Fields)
+		}
+	}
+
+	walk(m.Fields)
+	for _, s := range m.CommonStructs {
+		structs = append(structs, s)
+		walk(s.Fields)
+	}
+	return structs
+}
+
+// streamedStructs returns the names of the structs that hold records, or
+// other streamed structs.
+func streamedStructs(structs []*schema.CommonStruct) map[string]bool {
+	streamed := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, s := range structs {
+			if streamed[s.Name] {
+				continue
+			}
+			for _, f := range s.Fields {
+				if !isTagged(f) && isStreamedField(f, streamed) {
+					streamed[s.Name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return streamed
+}
+
+// isStreamedField reports whether f is records, or holds them in a struct
+// that is streamed. Lazy arrays are read into memory whatever they hold.
+func isStreamedField(f *schema.Field, streamed map[string]bool) bool {
+	if f.ViewOf != nil {
+		return false
+	}
+	if f.Type.Elem == "records" {
+		return !f.Type.Array
+	}
+	return streamed[f.Type.Elem]
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+// usesTables reports whether any of the messages is generated with field
+// tables, which need the unsafe package.
+func usesTables(ms ...*schema.MessageData) bool {
+	for _, m := range ms {
+		if *tables && !isInlined(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// genStructTable writes the field table of a struct, and the decode, encode
+// and size methods that use it in place of the per-field methods.
+func genStructTable(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	table := tableName(recv)
+
+	w.WriteString("var ")
+	w.WriteString(table)
+	w.WriteString(" = &structTable{\nflexible: ")
+	genVersionRange(w, m.FlexibleVersions)
+	for _, f := range fields {
+		if isTagged(f) {
+			w.WriteString(",\ntagged: true")
+			break
+		}
+	}
+	w.WriteString(",\nfields: []fieldTable{\n")
+	for _, f := range fields {
+		genFieldTable(w, m, recv, f)
+	}
+	w.WriteString("},\n}\n\n")
+
+	begMethod(w, recv, "decode", "d *decoder, v int16", "")
+	w.WriteString("decodeStruct(d, ")
+	w.WriteString(table)
+	w.WriteString(", unsafe.Pointer(m), v)\n")
+	endMethod(w)
+
+	begMethod(w, recv, "encode", "e *encoder, v int16", "")
+	w.WriteString("encodeStruct(e, ")
+	w.WriteString(table)
+	w.WriteString(", unsafe.Pointer(m), v)\n")
+	endMethod(w)
+
+	begMethod(w, recv, "size", "v int16", "int")
+	w.WriteString("return sizeStruct(")
+	w.WriteString(table)
+	w.WriteString(", unsafe.Pointer(m), v)\n")
+	endMethod(w)
+}
+
+func genFieldTable(w *codegen.File, m *schema.MessageData, recv string, f *schema.Field) {
+	t := &f.Type
+	if f.ViewOf != nil {
+		panic("lazy field " + f.Name + " cannot use a table codec")
+	}
+
+	w.WriteString("{\noffset: unsafe.Offsetof(")
+	w.WriteString(recv)
+	w.WriteString("{}.")
+	w.WriteString(f.Name)
+	w.WriteString("),\nkind: ")
+	w.WriteString(fieldKind(f))
+	w.WriteString(",\nversions: ")
+	genVersionRange(w, f.Versions)
+	if isNullable(f) {
+		w.WriteString(",\nnullable: ")
+		genVersionRange(w, f.NullableVersions)
+	} else {
+		w.WriteString(",\nnullable: versionRange{0, -1}")
+	}
+	w.WriteString(",\nflexible: ")
+	genVersionRange(w, flexibleVersions(m, f))
+	w.WriteString(",\n")
+
+	if isTagged(f) {
+		w.WriteString("tagged: true,\ntag: ")
+		w.WriteInt(int64(*f.Tag), 10)
+		w.WriteString(",\ntaggedVersions: ")
+		genVersionRange(w, f.TaggedVersions)
+		w.WriteString(",\n")
+	}
+
+	if !t.Array {
+		switch t.Elem {
+		case "bool", "boolean":
+			if f.Default.Boolean() {
+				w.WriteString("def: 1,\n")
+			}
+		case "int8", "int16", "int32", "int64":
+			bits, _ := strconv.Atoi(t.Elem[3:])
+			if d := f.Default.Integer(bits); d != 0 {
+				w.WriteString("def: ")
+				w.WriteInt(d, 10)
+				w.WriteString(",\n")
+			}
+		case "string":
+			if s := f.Default.String(); !isNullable(f) && !f.Default.Null() && s != "" {
+				w.WriteString("defString: ")
+				w.WriteQuoted(s)
+				w.WriteString(",\n")
+			}
+		}
+	}
+
+	switch {
+	case t.Elem == "bytes" && decodeConv(f) == "copyBytes":
+		w.WriteString("copy: true,\n")
+	case t.Elem == "string" && *zeroCopy:
+		w.WriteString("noCopy: true,\n")
+	}
+
+	if isStructType(t.Elem) {
+		w.WriteString("elem: ")
+		w.WriteString(tableName(t.Elem))
+		w.WriteString(",\n")
+	}
+
+	if t.Array {
+		if reusesArray(m, f) {
+			w.WriteString("reuse: true,\n")
+		}
+		w.WriteString("slice: ")
+		genFieldType(w, f)
+		w.WriteString("(nil),\nelemSize: unsafe.Sizeof(")
+		genFieldType(w, f)
+		w.WriteString("(nil)[0]),\n")
+	}
+
+	if len(f.KeyFields) != 0 {
+		keys := *f.Versions
+		for _, k := range f.KeyFields {
+			if k.Versions.Min > keys.Min {
+				keys.Min = k.Versions.Min
+			}
+			if k.Versions.Max != -1 && (keys.Max == -1 || k.Versions.Max < keys.Max) {
+				keys.Max = k.Versions.Max
+			}
+		}
+		w.WriteString("keys: ")
+		genVersionRange(w, &keys)
+		w.WriteString(",\ndup: func(p unsafe.Pointer) bool {\nreturn (*")
+		genFieldType(w, f)
+		w.WriteString(")(p).hasDuplicates()\n},\n")
+	}
+
+	w.WriteString("},\n")
+}
+
+// genVersionRange writes r as a runtime versionRange, in which an open range
+// ends at the highest possible version.
+func genVersionRange(w *codegen.File, r *schema.VersionRange) {
+	switch {
+	case r == nil || r.Min == -1:
+		w.WriteString("versionRange{0, -1}")
+	case r.Max == -1:
+		w.WriteString("versionRange{")
+		w.WriteInt(int64(r.Min), 10)
+		w.WriteString(", 32767}")
+	default:
+		w.WriteString("versionRange{")
+		w.WriteInt(int64(r.Min), 10)
+		w.WriteString(", ")
+		w.WriteInt(int64(r.Max), 10)
+		w.WriteString("}")
+	}
+}
+
+func fieldKind(f *schema.Field) string {
+	switch t := f.Type.Elem; t {
+	case "bool", "boolean":
+		return "kindBool"
+	case "int8":
+		return "kindInt8"
+	case "int16":
+		return "kindInt16"
+	case "int32":
+		return "kindInt32"
+	case "int64":
+		return "kindInt64"
+	case "string":
+		if !f.Type.Array && isNullable(f) {
+			return "kindNullableString"
+		}
+		return "kindString"
+	case "bytes", "records":
+		return "kindBytes"
+	default:
+		if isStructType(t) {
+			return "kindStruct"
+		}
+		panic("no kind for " + t)
+	}
+}
+
+func tableName(name string) string {
+	return strings.ToLower(name[:1]) + name[1:] + "Table"
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/betawaffle/kafka-gen-go/codegen"
+	"github.com/betawaffle/kafka-gen-go/schema"
+)
+
+func genFieldValidate(w *codegen.File, m *schema.MessageData, f *schema.Field) {
+	t := &f.Type
+
+	// Nil slices are encoded as empty where they are not nullable, so only
+	// strings can hold a null that the version cannot encode.
+	nullCheck := !t.Array && t.Elem == "string" && isNullable(f) &&
+		!versionsCover(f.NullableVersions, f.Versions)
+	present := func() {
+		if nullCheck {
+			w.WriteString("if m.")
+			w.WriteString(f.Name)
+			w.WriteString(" == nil && !(")
+			genVersionCond(w, f.NullableVersions, f.Versions)
+			w.WriteString(") {\nc.null(")
+			w.WriteQuoted(f.Name)
+			w.WriteString(", v)\n}\n")
+		}
+		if !isStructType(t.Elem) {
+			return
+		}
+		if f.ViewOf != nil {
+			w.WriteString("for i, n := 0, m.")
+			w.WriteString(f.Name)
+			w.WriteString(".Len(); i < n; i++ {\nc.push(")
+			w.WriteQuoted(f.Name)
+			w.WriteString(", i)\nm.")
+			w.WriteString(f.Name)
+			w.WriteString(".At(i).validate(c, v)\nc.pop()\n}\n")
+			return
+		}
+		if t.Array {
+			w.WriteString("for i := range m.")
+			w.WriteString(f.Name)
+			w.WriteString(" {\nc.push(")
+			w.WriteQuoted(f.Name)
+			w.WriteString(", i)\nm.")
+			w.WriteString(f.Name)
+			w.WriteString("[i].validate(c, v)\nc.pop()\n}\n")
+		} else {
+			w.WriteString("c.push(")
+			w.WriteQuoted(f.Name)
+			w.WriteString(", -1)\nm.")
+			w.WriteString(f.Name)
+			w.WriteString(".validate(c, v)\nc.pop()\n")
+		}
+	}
+
+	// A struct is set if it differs from a reset one in any version.
+	single := !t.Array && f.ViewOf == nil && isStructType(t.Elem)
+	absent := func() {
+		if single {
+			w.WriteString("{\nvar d ")
+			genFieldType(w, f)
+			w.WriteString("\nd.Reset()\nif !m.")
+			w.WriteString(f.Name)
+			w.WriteString(".equal(&d, -1)")
+		} else {
+			w.WriteString("if ")
+			genFieldNonDefault(w, f)
+		}
+		w.WriteString(" {\nc.absent(")
+		w.WriteQuoted(f.Name)
+		w.WriteString(", v, ")
+		w.WriteBool(f.Ignorable)
+		w.WriteString(")\n}\n")
+		if single {
+			w.WriteString("}\n")
+		}
+	}
+
+	switch {
+	case versionsCover(f.Versions, m.ValidVersions):
+		present()
+	case !versionsOverlap(f.Versions, m.ValidVersions):
+		absent()
+	case nullCheck || isStructType(t.Elem):
+		w.WriteString("if ")
+		genVersionCond(w, f.Versions, m.ValidVersions)
+		w.WriteString(" {\n")
+		present()
+		w.WriteString("} else ")
+		absent()
+	default:
+		w.WriteString("if !(")
+		genVersionCond(w, f.Versions, m.ValidVersions)
+		w.WriteString(") {\n")
+		absent()
+		w.WriteString("}\n")
+	}
+}
+
+func genStructValidate(w *codegen.File, m *schema.MessageData, recv string, fields []*schema.Field) {
+	begMethod(w, recv, "validate", "c *validator, v int16", "")
+
+	for _, f := range fields {
+		genFieldValidate(w, m, f)
+	}
+
+	endMethod(w)
+}